@@ -0,0 +1,273 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/filter"
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+AggregateConfig selects the rows Count and Aggregate read
+*/
+type AggregateConfig struct {
+	Range    KeyRange
+	Filter   filter.Filter // server side filter, nil for all rows
+	Parallel int           // regions scanned at once, 4 by default
+
+	// Decode return the number held by a cell, strconv.ParseFloat of the
+	// text by default, the way PutTyped stores numbers
+	Decode func(value []byte) (float64, error)
+
+	Precision uint8 // of the distinct values sketch, 14 by default
+
+	Progress         func(p ScanProgress) // called every ProgressInterval and at the end
+	ProgressInterval time.Duration        // 10s by default
+}
+
+/*
+ScanProgress tells how far a parallel scan went
+*/
+type ScanProgress struct {
+	Ranges     int   // regions overlapping the key range
+	RangesDone int   // regions scanned to the end
+	Rows       int64 // rows scanned
+	Elapsed    time.Duration
+}
+
+/*
+AggregateProgress is the progress of a Count or Aggregate, the name
+ScanProgress had before bulk deletes shared it
+*/
+type AggregateProgress = ScanProgress
+
+/*
+Aggregates of a column over the rows holding it
+*/
+type Aggregates struct {
+	Rows     int64 // rows holding the column
+	Count    int64 // values which are numbers
+	Skipped  int64 // values which are not numbers
+	Sum      float64
+	Min      float64
+	Max      float64
+	Distinct *HyperLogLog // of the raw values
+}
+
+/*
+Avg return the mean of the numeric values, NaN when there are none
+*/
+func (a *Aggregates) Avg() float64 {
+	if a.Count == 0 {
+		return math.NaN()
+	}
+	return a.Sum / float64(a.Count)
+}
+
+func (a *Aggregates) add(value []byte, decode func([]byte) (float64, error)) {
+	a.Rows++
+	a.Distinct.Add(value)
+	n, err := decode(value)
+	if err != nil {
+		a.Skipped++
+		return
+	}
+	if a.Count == 0 || n < a.Min {
+		a.Min = n
+	}
+	if a.Count == 0 || n > a.Max {
+		a.Max = n
+	}
+	a.Count++
+	a.Sum += n
+}
+
+func (a *Aggregates) merge(o *Aggregates) {
+	if o.Count > 0 {
+		if a.Count == 0 || o.Min < a.Min {
+			a.Min = o.Min
+		}
+		if a.Count == 0 || o.Max > a.Max {
+			a.Max = o.Max
+		}
+	}
+	a.Rows += o.Rows
+	a.Count += o.Count
+	a.Skipped += o.Skipped
+	a.Sum += o.Sum
+	a.Distinct.Merge(o.Distinct)
+}
+
+func parseNumber(value []byte) (float64, error) {
+	return strconv.ParseFloat(string(value), 64)
+}
+
+/*
+parallelScan scans the regions of a table overlapping a key range in
+parallel, each with a client of the pool
+*/
+type parallelScan struct {
+	pool     *Pool
+	table    string
+	kr       KeyRange
+	parallel int
+	progress func(p ScanProgress)
+	interval time.Duration
+	started  time.Time
+
+	ranges int
+	done   int64
+	rows   int64
+}
+
+func newParallelScan(pool *Pool, tableName string, kr KeyRange, parallel int, progress func(ScanProgress), interval time.Duration) *parallelScan {
+	if parallel <= 0 {
+		parallel = 4
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &parallelScan{
+		pool:     pool,
+		table:    tableName,
+		kr:       kr,
+		parallel: parallel,
+		progress: progress,
+		interval: interval,
+		started:  time.Now(),
+	}
+}
+
+func (ps *parallelScan) current() ScanProgress {
+	return ScanProgress{
+		Ranges:     ps.ranges,
+		RangesDone: int(atomic.LoadInt64(&ps.done)),
+		Rows:       atomic.LoadInt64(&ps.rows),
+		Elapsed:    time.Since(ps.started),
+	}
+}
+
+/*
+run scans each region with the scan. each is called once per region with
+its client for the functions taking the rows of the region and ending it,
+end is not called for a region which failed.
+*/
+func (ps *parallelScan) run(ctx context.Context, scan TScan, each func(client *HClient) (add func(row *hbase1.TRowResult_) error, end func() error)) error {
+	client, err := ps.pool.Get()
+	if err != nil {
+		return err
+	}
+	ranges, err := regionRanges(client, ps.table, ps.kr)
+	ps.pool.Put(client, err)
+	if err != nil {
+		return err
+	}
+	ps.ranges = len(ranges)
+
+	if ps.progress != nil {
+		stop := everyInterval(ps.interval, func() { ps.progress(ps.current()) })
+		defer func() {
+			stop()
+			ps.progress(ps.current())
+		}()
+	}
+
+	return forEachRange(ctx, ranges, ps.parallel, func(ctx context.Context, r KeyRange) (err error) {
+		client, err := ps.pool.Get()
+		if err != nil {
+			return err
+		}
+		defer func() { ps.pool.Put(client, err) }()
+
+		sub := scan
+		sub.StartRow, sub.StopRow = r.Start, r.Stop
+		add, end := each(client)
+		err = scanAll(client, ps.table, &sub, nil, func(row *hbase1.TRowResult_) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			atomic.AddInt64(&ps.rows, 1)
+			return add(row)
+		})
+		if err == nil {
+			err = end()
+		}
+		if err == nil {
+			atomic.AddInt64(&ps.done, 1)
+		}
+		return err
+	})
+}
+
+/*
+Count counts the rows of a table with parallel region scans. Without a
+filter only the first key of each row is sent back, with one the values
+are left out.
+*/
+func Count(ctx context.Context, pool *Pool, tableName string, conf AggregateConfig) (int64, error) {
+	ps := newParallelScan(pool, tableName, conf.Range, conf.Parallel, conf.Progress, conf.ProgressInterval)
+
+	scan := TScan{Caching: 1000}
+	if conf.Filter != nil {
+		// FirstKeyOnly would hide the columns a value filter tests
+		scan.SetFilter(filter.And(conf.Filter, filter.KeyOnly()))
+	} else {
+		scan.SetFilter(filter.And(filter.FirstKeyOnly(), filter.KeyOnly()))
+	}
+
+	err := ps.run(ctx, scan, func(*HClient) (func(*hbase1.TRowResult_) error, func() error) {
+		return func(*hbase1.TRowResult_) error { return nil }, func() error { return nil }
+	})
+	return ps.current().Rows, err
+}
+
+/*
+Aggregate computes the count, sum, min, max and distinct values of a
+column with parallel region scans
+*/
+func Aggregate(ctx context.Context, pool *Pool, tableName, column string, conf AggregateConfig) (*Aggregates, error) {
+	if conf.Decode == nil {
+		conf.Decode = parseNumber
+	}
+	if conf.Precision == 0 {
+		conf.Precision = 14
+	}
+	ps := newParallelScan(pool, tableName, conf.Range, conf.Parallel, conf.Progress, conf.ProgressInterval)
+	family, qualifier := SplitColumn(column)
+
+	scan := TScan{Caching: 1000, Columns: []string{column}}
+	if conf.Filter != nil {
+		scan.SetFilter(conf.Filter)
+	}
+
+	var mu sync.Mutex
+	total := &Aggregates{Distinct: NewHyperLogLog(conf.Precision)}
+	err := ps.run(ctx, scan, func(*HClient) (func(*hbase1.TRowResult_) error, func() error) {
+		// each region adds to its own aggregates, merged at its end
+		local := &Aggregates{Distinct: NewHyperLogLog(conf.Precision)}
+		add := func(r *hbase1.TRowResult_) error {
+			if c := ToRow(r).Cell(family, qualifier); c != nil {
+				local.add(c.Value, conf.Decode)
+			}
+			return nil
+		}
+		end := func() error {
+			mu.Lock()
+			total.merge(local)
+			mu.Unlock()
+			return nil
+		}
+		return add, end
+	})
+	return total, err
+}
@@ -0,0 +1,273 @@
+/*
+Package backup keeps a chain of table exports in a local directory: a
+full export, then incremental exports of the cells written since the
+previous one. The directory holds manifest.json, describing the chain,
+and one gzipped JSON Lines file per export, in the format of goh export
+with base64 encoding:
+
+	{"row":"dTE=","cells":[{"column":"aW5mbzpuYW1l","timestamp":1500000000000,"value":"Ym9i"}]}
+
+Restore replays the chain into a table up to a point in time, keeping the
+timestamps of the cells. Scans do not return deletes, a restore brings
+back cells deleted after they were exported.
+*/
+
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/chenjingping/goh"
+)
+
+/*
+ErrNoManifest is returned when the directory holds no backup
+*/
+var ErrNoManifest = errors.New("backup: no manifest in directory")
+
+const manifestFile = "manifest.json"
+
+/*
+Entry is one export of the chain, holding the cells written at a
+timestamp in [Since, Until)
+*/
+type Entry struct {
+	File    string    `json:"file"`
+	Full    bool      `json:"full"`
+	Since   int64     `json:"since"` // ms
+	Until   int64     `json:"until"` // ms
+	Rows    int64     `json:"rows"`
+	Cells   int64     `json:"cells"`
+	Created time.Time `json:"created"`
+}
+
+/*
+Manifest describes the backups of a table, oldest first
+*/
+type Manifest struct {
+	Table    string                  `json:"table"`
+	Families []*goh.ColumnDescriptor `json:"families"`
+	Entries  []*Entry                `json:"entries"`
+}
+
+/*
+LoadManifest reads the manifest of a backup directory
+*/
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("backup: %s: %v", manifestFile, err)
+	}
+	return m, nil
+}
+
+func (m *Manifest) save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, manifestFile), func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+/*
+Chain return the entries to replay for the state at until (ms), the
+latest full backup ended by then and the incrementals following it; 0
+for the latest state. A full backup ended after until holds values
+overwritten since, there is no chain for that state then.
+*/
+func (m *Manifest) Chain(until int64) ([]*Entry, error) {
+	base := -1
+	for i, e := range m.Entries {
+		if e.Full && (until == 0 || e.Until <= until) {
+			base = i
+		}
+	}
+	if base < 0 && until > 0 {
+		return nil, fmt.Errorf("backup: no full backup ended by %s", time.UnixMilli(until).Format(time.RFC3339))
+	}
+	if base < 0 {
+		return nil, errors.New("backup: the chain has no full backup")
+	}
+
+	chain := []*Entry{m.Entries[base]}
+	for _, e := range m.Entries[base+1:] {
+		if e.Full || (until > 0 && e.Since >= until) {
+			break
+		}
+		chain = append(chain, e)
+	}
+	return chain, nil
+}
+
+/*
+Options of a backup
+*/
+type Options struct {
+	Full     bool     // start a new chain even when there is one
+	Columns  []string // families or family:qualifier columns, all when empty
+	Versions int      // versions of each column read through thrift1, 1 by default
+
+	// Source scans the changes, the client by default. A goh.Thrift2Source
+	// applies the time range on the server and returns every version.
+	Source goh.ChangeSource
+}
+
+/*
+Run exports the table to dir: a full export when the directory has no
+chain or Full is set, the cells written since the previous export
+otherwise
+*/
+func Run(ctx context.Context, client *goh.HClient, tableName, dir string, opts Options) (*Entry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	m, err := LoadManifest(dir)
+	if err == ErrNoManifest {
+		m, err = &Manifest{Table: tableName}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Table != tableName {
+		return nil, fmt.Errorf("backup: %s holds backups of table %s", dir, m.Table)
+	}
+
+	families, err := client.GetColumnDescriptors(tableName)
+	if err != nil {
+		return nil, err
+	}
+	m.Families = m.Families[:0]
+	for _, f := range families {
+		m.Families = append(m.Families, f)
+	}
+	sort.Slice(m.Families, func(i, j int) bool { return m.Families[i].Name < m.Families[j].Name })
+
+	e := &Entry{Full: opts.Full || len(m.Entries) == 0, Created: time.Now()}
+	if !e.Full {
+		e.Since = m.Entries[len(m.Entries)-1].Until
+	}
+	e.Until = e.Created.UnixMilli()
+	kind := "incr"
+	if e.Full {
+		kind = "full"
+	}
+	e.File = fmt.Sprintf("%04d-%s-%d.jsonl.gz", len(m.Entries)+1, kind, e.Until)
+
+	source := opts.Source
+	if source == nil {
+		source = client
+	}
+	err = writeFile(filepath.Join(dir, e.File), func(f *os.File) error {
+		zw := gzip.NewWriter(f)
+		bw := bufio.NewWriter(zw)
+		enc := json.NewEncoder(bw)
+		err := source.ScanChanges(ctx, tableName, opts.Columns, e.Since, e.Until, func(row *goh.Row) error {
+			if opts.Versions > 1 && opts.Source == nil {
+				var err error
+				if row, err = readVersions(client, tableName, row, e.Since, e.Until, opts.Versions); err != nil {
+					return err
+				}
+			}
+			e.Rows++
+			e.Cells += int64(len(row.Cells))
+			return enc.Encode(toRecord(row))
+		})
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.Entries = append(m.Entries, e)
+	if err = m.save(dir); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+/*
+readVersions replaces the cells of the row by up to n versions of its
+columns written in [since, until)
+*/
+func readVersions(client *goh.HClient, tableName string, row *goh.Row, since, until int64, n int) (*goh.Row, error) {
+	versions := &goh.Row{Key: row.Key}
+	for _, col := range row.Columns() {
+		cells, err := client.ReadVersionsTs(tableName, row.Key, col, until, int32(n), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cells {
+			if c.Timestamp >= since {
+				versions.Cells = append(versions.Cells, c)
+			}
+		}
+	}
+	return versions, nil
+}
+
+type cell struct {
+	Column    []byte `json:"column"`
+	Timestamp int64  `json:"timestamp"`
+	Value     []byte `json:"value"`
+}
+
+type record struct {
+	Row   []byte `json:"row"`
+	Cells []cell `json:"cells"`
+}
+
+func toRecord(row *goh.Row) *record {
+	r := &record{Row: row.Key, Cells: make([]cell, len(row.Cells))}
+	for i, c := range row.Cells {
+		r.Cells[i] = cell{Column: []byte(c.Column()), Timestamp: c.Timestamp, Value: c.Value}
+	}
+	return r
+}
+
+/*
+writeFile writes a file through a temporary one, so a failed write
+leaves no partial file
+*/
+func writeFile(name string, write func(f *os.File) error) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,114 @@
+/*
+
+
+ */
+
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/chenjingping/goh"
+)
+
+/*
+RestoreOptions of a restore
+*/
+type RestoreOptions struct {
+	Until      int64   // point in time (ms), cells written at or after it are left out; 0 for the latest state
+	Create     bool    // create the table with the families of the manifest when it does not exist
+	BatchRows  int     // rows per MutateRowsTs call, 1000 by default
+	RowsPerSec float64 // throttle, 0 for no limit
+}
+
+/*
+RestoreResult counts what a restore wrote
+*/
+type RestoreResult struct {
+	Entries []*Entry // the chain replayed
+	Rows    int64
+	Cells   int64
+}
+
+/*
+Restore replays the chain of dir into the table, oldest export first.
+The cells keep their timestamps, they are grouped by timestamp and sent
+with MutateRowsTs.
+*/
+func Restore(ctx context.Context, client *goh.HClient, dir, tableName string, opts RestoreOptions) (*RestoreResult, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := m.Chain(opts.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Create {
+		if _, err = client.CreateTableIfNotExists(tableName, m.Families); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &RestoreResult{Entries: chain}
+	bm := goh.NewBufferedMutator(client, tableName, goh.MutatorConfig{MaxRows: opts.BatchRows, RowsPerSec: opts.RowsPerSec})
+	for _, e := range chain {
+		if err = replay(ctx, bm, filepath.Join(dir, e.File), opts.Until, res); err != nil {
+			bm.Close()
+			return res, fmt.Errorf("backup: %s: %w", e.File, err)
+		}
+	}
+	if err = bm.Close(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func replay(ctx context.Context, bm *goh.BufferedMutator, name string, until int64, res *RestoreResult) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	sc := bufio.NewScanner(zr)
+	sc.Buffer(make([]byte, 64<<10), 256<<20)
+	for line := 1; sc.Scan(); line++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		var r record
+		if err = json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		m := goh.NewRowMutation(r.Row)
+		for _, c := range r.Cells {
+			if until > 0 && c.Timestamp >= until {
+				continue
+			}
+			m.Timestamp(c.Timestamp).Put(string(c.Column), c.Value)
+		}
+		if m.Len() == 0 {
+			continue
+		}
+		if err = bm.Mutate(m); err != nil {
+			return err
+		}
+		res.Rows++
+		res.Cells += int64(m.Len())
+	}
+	return sc.Err()
+}
@@ -0,0 +1,220 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+BatcherStats counts the coalesced reads
+*/
+type BatcherStats struct {
+	Calls   int64 // GetRow calls received
+	Batches int64 // GetRows calls sent
+	Keys    int64 // distinct keys sent
+	Deduped int64 // calls served by another caller's identical key
+}
+
+type readResult struct {
+	rows []*hbase1.TRowResult_
+	err  error
+}
+
+/*
+readBatch collects the keys of one table and column list
+*/
+type readBatch struct {
+	tableName  string
+	columns    []string
+	attributes map[string]string
+	keys       [][]byte
+	waiters    map[string][]chan readResult
+}
+
+/*
+ReadBatcher coalesces concurrent single-row reads of the same table and
+columns into one GetRows call. A batch is sent when Window elapsed after
+its first key or when it holds MaxKeys keys; callers asking for the same
+key share one lookup.
+*/
+type ReadBatcher struct {
+	pool    *Pool
+	window  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	batches map[string]*readBatch
+
+	calls   int64
+	sent    int64
+	keys    int64
+	deduped int64
+}
+
+/*
+NewReadBatcher return a batcher sending through pool
+*/
+func NewReadBatcher(pool *Pool, window time.Duration, maxKeys int) *ReadBatcher {
+	if maxKeys < 1 {
+		maxKeys = 100
+	}
+	return &ReadBatcher{
+		pool:    pool,
+		window:  window,
+		maxKeys: maxKeys,
+		batches: make(map[string]*readBatch),
+	}
+}
+
+/*
+Stats return the batching counters
+*/
+func (b *ReadBatcher) Stats() BatcherStats {
+	return BatcherStats{
+		Calls:   atomic.LoadInt64(&b.calls),
+		Batches: atomic.LoadInt64(&b.sent),
+		Keys:    atomic.LoadInt64(&b.keys),
+		Deduped: atomic.LoadInt64(&b.deduped),
+	}
+}
+
+/*
+GetRow is HClient.GetRow served by a batched GetRows
+*/
+func (b *ReadBatcher) GetRow(tableName string, row []byte, attributes map[string]string) ([]*hbase1.TRowResult_, error) {
+	return b.GetRowWithColumns(tableName, row, nil, attributes)
+}
+
+/*
+GetRowWithColumns is HClient.GetRowWithColumns served by a batched
+GetRowsWithColumns
+*/
+func (b *ReadBatcher) GetRowWithColumns(tableName string, row []byte, columns []string, attributes map[string]string) ([]*hbase1.TRowResult_, error) {
+	atomic.AddInt64(&b.calls, 1)
+
+	key := batchKey(tableName, columns, attributes)
+	ch := make(chan readResult, 1)
+
+	b.mu.Lock()
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &readBatch{
+			tableName:  tableName,
+			columns:    columns,
+			attributes: attributes,
+			waiters:    make(map[string][]chan readResult),
+		}
+		b.batches[key] = batch
+		time.AfterFunc(b.window, func() { b.flush(key, batch) })
+	}
+
+	waiters, dup := batch.waiters[string(row)]
+	if dup {
+		atomic.AddInt64(&b.deduped, 1)
+	} else {
+		batch.keys = append(batch.keys, row)
+	}
+	batch.waiters[string(row)] = append(waiters, ch)
+	full := len(batch.keys) >= b.maxKeys
+	b.mu.Unlock()
+
+	if full {
+		b.flush(key, batch)
+	}
+
+	r := <-ch
+	return r.rows, r.err
+}
+
+/*
+flush sends the batch unless it was sent already
+*/
+func (b *ReadBatcher) flush(key string, batch *readBatch) {
+	b.mu.Lock()
+	if b.batches[key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, key)
+	b.mu.Unlock()
+
+	atomic.AddInt64(&b.sent, 1)
+	atomic.AddInt64(&b.keys, int64(len(batch.keys)))
+
+	rows, err := b.fetch(batch)
+	if err != nil {
+		for _, waiters := range batch.waiters {
+			for _, ch := range waiters {
+				ch <- readResult{err: err}
+			}
+		}
+		return
+	}
+
+	found := make(map[string]*hbase1.TRowResult_, len(rows))
+	for _, r := range rows {
+		found[string(r.Row)] = r
+	}
+
+	for k, waiters := range batch.waiters {
+		var res []*hbase1.TRowResult_
+		if r, ok := found[k]; ok {
+			res = []*hbase1.TRowResult_{r}
+		}
+		for _, ch := range waiters {
+			ch <- readResult{rows: res}
+		}
+	}
+}
+
+func (b *ReadBatcher) fetch(batch *readBatch) (rows []*hbase1.TRowResult_, err error) {
+	client, err := b.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { b.pool.Put(client, err) }()
+
+	if batch.columns == nil {
+		return client.GetRows(batch.tableName, batch.keys, batch.attributes)
+	}
+	return client.GetRowsWithColumns(batch.tableName, batch.keys, batch.columns, batch.attributes)
+}
+
+/*
+batchKey identifies the calls which may share one GetRows
+*/
+func batchKey(tableName string, columns []string, attributes map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString(tableName)
+
+	if columns == nil {
+		sb.WriteString("\x00*")
+	}
+	for _, c := range columns {
+		sb.WriteString("\x00c")
+		sb.WriteString(c)
+	}
+
+	names := make([]string, 0, len(attributes))
+	for k := range attributes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		sb.WriteString("\x00a")
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(attributes[k])
+	}
+	return sb.String()
+}
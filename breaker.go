@@ -0,0 +1,277 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+	"github.com/chenjingping/thrift/lib/go/thrift"
+)
+
+/*
+BreakerState is the state of a circuit breaker
+*/
+type BreakerState int
+
+/*
+breaker states
+*/
+const (
+	BreakerClosed   BreakerState = iota // calls pass
+	BreakerOpen                         // calls are rejected
+	BreakerHalfOpen                     // probe calls pass
+)
+
+/*
+String
+*/
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+/*
+BreakerConfig holds the thresholds of a circuit breaker
+*/
+type BreakerConfig struct {
+	ConsecutiveFailures int           // failures in a row which open the breaker, 0 disables
+	ErrorRate           float64       // failure ratio within Window which opens the breaker, 0 disables
+	MinRequests         int           // calls within Window before ErrorRate applies
+	Window              time.Duration // length of the error rate window
+	OpenTimeout         time.Duration // time spent open before probing
+	HalfOpenProbes      int           // successful probes needed to close again
+}
+
+/*
+DefaultBreakerConfig return the breaker config used when none is given
+*/
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		ConsecutiveFailures: 5,
+		ErrorRate:           0.5,
+		MinRequests:         20,
+		Window:              10 * time.Second,
+		OpenTimeout:         5 * time.Second,
+		HalfOpenProbes:      1,
+	}
+}
+
+/*
+BreakerStats is a snapshot of a circuit breaker
+*/
+type BreakerStats struct {
+	State               BreakerState // current state
+	Calls               int          // calls in the current window
+	Failures            int          // failed calls in the current window
+	ConsecutiveFailures int          // failures since the last success
+	Opened              int64        // times the breaker opened
+	Rejected            int64        // calls rejected while open
+}
+
+/*
+CircuitBreaker stops sending calls to an endpoint which keeps failing and
+lets a few probe calls through once OpenTimeout elapsed
+*/
+type CircuitBreaker struct {
+	cfg BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	probeOK     int
+	opened      int64
+	rejected    int64
+}
+
+/*
+NewCircuitBreaker return a closed circuit breaker
+*/
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	return &CircuitBreaker{
+		cfg:         cfg,
+		windowStart: time.Now(),
+	}
+}
+
+/*
+Allow return ErrCircuitOpen when the call must not be sent
+*/
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			b.rejected++
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probes = 0
+		b.probeOK = 0
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.cfg.HalfOpenProbes {
+			b.rejected++
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+/*
+Done records the outcome of a call admitted by Allow
+*/
+func (b *CircuitBreaker) Done(err error) {
+	failed := isBreakerFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip()
+			return
+		}
+		b.probeOK++
+		if b.probeOK >= b.cfg.HalfOpenProbes {
+			b.reset()
+		}
+
+	case BreakerClosed:
+		now := time.Now()
+		if b.cfg.Window > 0 && now.Sub(b.windowStart) > b.cfg.Window {
+			b.windowStart = now
+			b.calls = 0
+			b.failures = 0
+		}
+
+		b.calls++
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+
+		if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+			b.trip()
+			return
+		}
+		if b.cfg.ErrorRate > 0 && b.calls >= b.cfg.MinRequests &&
+			float64(b.failures)/float64(b.calls) >= b.cfg.ErrorRate {
+			b.trip()
+		}
+	}
+}
+
+func (b *CircuitBreaker) trip() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.opened++
+}
+
+func (b *CircuitBreaker) reset() {
+	b.state = BreakerClosed
+	b.consecutive = 0
+	b.windowStart = time.Now()
+	b.calls = 0
+	b.failures = 0
+}
+
+/*
+State return the current state
+*/
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+/*
+Stats return a snapshot of the breaker
+*/
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		State:               b.state,
+		Calls:               b.calls,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutive,
+		Opened:              b.opened,
+		Rejected:            b.rejected,
+	}
+}
+
+/*
+isBreakerFailure reports whether err says the endpoint is unhealthy: the
+connection broke or the gateway could not reach the cluster. Errors about
+a table, such as a missing one, or about the arguments do not count.
+*/
+func isBreakerFailure(err error) bool {
+	switch e := err.(type) {
+	case nil, *hbase1.IllegalArgument, *hbase1.AlreadyExists:
+		return false
+	case *hbase1.IOError:
+		return isUnavailable(e.Message)
+	case *HbaseError:
+		if e.IOErr != nil {
+			return isUnavailable(e.IOErr.Message)
+		}
+		return e.Err != nil && isBreakerFailure(e.Err)
+	}
+	return isTransportError(err)
+}
+
+/*
+isTransportError reports whether err comes from the connection rather
+than from the server
+*/
+func isTransportError(err error) bool {
+	var te thrift.TTransportException
+	var ne net.Error
+	return errors.As(err, &te) || errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+/*
+isUnavailable reports whether an IOError of the gateway names an
+exception of an unreachable or busy cluster
+*/
+func isUnavailable(message string) bool {
+	for _, name := range []string{
+		"RetriesExhaustedException", "NotServingRegionException", "ServerNotRunningYetException",
+		"RegionTooBusyException", "CallTimeoutException", "ConnectException", "PleaseHoldException",
+	} {
+		if strings.Contains(message, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+AttrCacheBypass is a call attribute which makes GetRow and
+GetRowWithColumns skip the row cache, it is not sent to the server
+*/
+const AttrCacheBypass = "goh.cache.bypass"
+
+/*
+CacheStats is a snapshot of a row cache
+*/
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	Invalidations int64
+	Entries       int
+	Bytes         int64
+}
+
+type cacheEntry struct {
+	rowKey  string
+	key     string
+	rows    []*hbase1.TRowResult_
+	size    int64
+	expires time.Time
+}
+
+/*
+RowCache is a LRU cache of row reads bounded by bytes, entries expire
+after ttl and are dropped when the row is mutated through a client using
+the cache. Cached results are shared and must not be modified.
+*/
+type RowCache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	byRow map[string]map[string]*list.Element
+	gens  [256]uint64
+	bytes int64
+	stats CacheStats
+}
+
+/*
+NewRowCache return a cache holding up to maxBytes, a ttl of 0 keeps
+entries until evicted or invalidated
+*/
+func NewRowCache(maxBytes int64, ttl time.Duration) *RowCache {
+	return &RowCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		byRow:    make(map[string]map[string]*list.Element),
+	}
+}
+
+/*
+SetRowCache serves GetRow and GetRowWithColumns from c and invalidates it
+on mutations, nil removes the cache. Clients sharing one cache see each
+other's invalidations.
+*/
+func (client *HClient) SetRowCache(c *RowCache) {
+	client.mu.Lock()
+	client.cache = c
+	client.mu.Unlock()
+}
+
+/*
+Stats return the cache counters
+*/
+func (c *RowCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.bytes
+	return stats
+}
+
+/*
+Invalidate drops every cached read of the row
+*/
+func (c *RowCache) Invalidate(tableName string, row []byte) {
+	rowKey := cacheRowKey(tableName, row)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gens[genSlot(rowKey)]++
+	for _, el := range c.byRow[rowKey] {
+		c.remove(el)
+		c.stats.Invalidations++
+	}
+}
+
+/*
+Purge drops every entry
+*/
+func (c *RowCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.gens {
+		c.gens[i]++
+	}
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.byRow = make(map[string]map[string]*list.Element)
+	c.bytes = 0
+}
+
+/*
+get return the cached rows and the generation of the row, used to store
+the result of a miss
+*/
+func (c *RowCache) get(rowKey, key string) ([]*hbase1.TRowResult_, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gen := c.gens[genSlot(rowKey)]
+	el, ok := c.items[key]
+	if ok {
+		e := el.Value.(*cacheEntry)
+		if c.ttl <= 0 || time.Now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			return e.rows, true, gen
+		}
+		c.remove(el)
+	}
+
+	c.stats.Misses++
+	return nil, false, gen
+}
+
+/*
+put stores rows unless the row was invalidated since gen was read
+*/
+func (c *RowCache) put(rowKey, key string, rows []*hbase1.TRowResult_, gen uint64) {
+	e := &cacheEntry{
+		rowKey: rowKey,
+		key:    key,
+		rows:   rows,
+		size:   int64(len(key)) + rowsSize(rows),
+	}
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	if e.size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gens[genSlot(rowKey)] != gen {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	el := c.lru.PushFront(e)
+	c.items[key] = el
+	if c.byRow[rowKey] == nil {
+		c.byRow[rowKey] = make(map[string]*list.Element)
+	}
+	c.byRow[rowKey][key] = el
+	c.bytes += e.size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *RowCache) remove(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.items, e.key)
+	if m := c.byRow[e.rowKey]; m != nil {
+		delete(m, e.key)
+		if len(m) == 0 {
+			delete(c.byRow, e.rowKey)
+		}
+	}
+	c.bytes -= e.size
+}
+
+func cacheRowKey(tableName string, row []byte) string {
+	return tableName + "\x00" + string(row)
+}
+
+func cacheKey(rowKey string, columns []string) string {
+	if columns == nil {
+		return rowKey + "\x00*"
+	}
+	sorted := append([]string(nil), columns...)
+	sort.Strings(sorted)
+	return rowKey + "\x00c" + strings.Join(sorted, "\x00")
+}
+
+func genSlot(rowKey string) int {
+	h := fnv.New32a()
+	h.Write([]byte(rowKey))
+	return int(h.Sum32() % 256)
+}
+
+func rowsSize(rows []*hbase1.TRowResult_) int64 {
+	var n int64
+	for _, r := range rows {
+		n += int64(len(r.Row))
+		for col, cell := range r.Columns {
+			n += int64(len(col)+len(cell.Value)) + 16
+		}
+		for _, col := range r.SortedColumns {
+			n += int64(len(col.ColumnName)) + 16
+			if col.Cell != nil {
+				n += int64(len(col.Cell.Value))
+			}
+		}
+	}
+	return n
+}
+
+/*
+readRow serves a single-row read from the cache of the client, fetch is
+called with the attributes to send on a miss
+*/
+func (client *HClient) readRow(tableName string, row []byte, columns []string, attributes map[string]string,
+	fetch func(attributes map[string]string) ([]*hbase1.TRowResult_, error)) ([]*hbase1.TRowResult_, error) {
+	bypass := false
+	if _, ok := attributes[AttrCacheBypass]; ok {
+		bypass = true
+		stripped := make(map[string]string, len(attributes))
+		for k, v := range attributes {
+			if k != AttrCacheBypass {
+				stripped[k] = v
+			}
+		}
+		attributes = stripped
+	}
+
+	client.mu.Lock()
+	c := client.cache
+	client.mu.Unlock()
+
+	if c == nil || bypass {
+		return fetch(attributes)
+	}
+
+	rowKey := cacheRowKey(tableName, row)
+	key := cacheKey(rowKey, columns)
+
+	rows, ok, gen := c.get(rowKey, key)
+	if ok {
+		return rows, nil
+	}
+
+	rows, err := fetch(attributes)
+	if err == nil {
+		c.put(rowKey, key, rows, gen)
+	}
+	return rows, err
+}
+
+/*
+invalidateRow drops the row from the cache of the client
+*/
+func (client *HClient) invalidateRow(tableName string, row []byte) {
+	client.mu.Lock()
+	c := client.cache
+	client.mu.Unlock()
+
+	if c != nil {
+		c.Invalidate(tableName, row)
+	}
+}
@@ -0,0 +1,372 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+	"github.com/chenjingping/goh/hbase2"
+)
+
+/*
+ChangeSource scans the cells of a table written at a timestamp in
+[minStamp, maxStamp)
+*/
+type ChangeSource interface {
+	ScanChanges(ctx context.Context, tableName string, columns []string, minStamp, maxStamp int64, fn func(row *Row) error) error
+}
+
+/*
+ScanChanges makes HClient a ChangeSource. thrift1 scans take an upper
+time bound only, every row is read and the cells older than minStamp are
+dropped client side; only the latest version below maxStamp is seen.
+*/
+func (client *HClient) ScanChanges(ctx context.Context, tableName string, columns []string, minStamp, maxStamp int64, fn func(row *Row) error) error {
+	scan := &TScan{Columns: columns, Timestamp: maxStamp, Caching: 1000}
+	return scanAll(client, tableName, scan, nil, func(r *hbase1.TRowResult_) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row := ToRow(r)
+		cells := row.Cells[:0]
+		for _, c := range row.Cells {
+			if c.Timestamp >= minStamp {
+				cells = append(cells, c)
+			}
+		}
+		if len(cells) == 0 {
+			return nil
+		}
+		row.Cells = cells
+		return fn(row)
+	})
+}
+
+/*
+Thrift2Source is a ChangeSource on a thrift2 gateway, the time range is
+applied by the server and every version in it is returned
+*/
+type Thrift2Source struct {
+	Client      *hbase2.THBaseServiceClient
+	MaxVersions int32 // versions of a column per scan, 1000 by default
+}
+
+/*
+ScanChanges scans with a TTimeRange
+*/
+func (s *Thrift2Source) ScanChanges(ctx context.Context, tableName string, columns []string, minStamp, maxStamp int64, fn func(row *Row) error) error {
+	caching := int32(1000)
+	scan := &hbase2.TScan{
+		Caching:     &caching,
+		MaxVersions: s.MaxVersions,
+		TimeRange:   &hbase2.TTimeRange{MinStamp: minStamp, MaxStamp: maxStamp},
+	}
+	if scan.MaxVersions <= 0 {
+		scan.MaxVersions = 1000
+	}
+	for _, column := range columns {
+		family, qualifier := SplitColumn(column)
+		tc := &hbase2.TColumn{Family: []byte(family)}
+		if qualifier != "" {
+			tc.Qualifier = []byte(qualifier)
+		}
+		scan.Columns = append(scan.Columns, tc)
+	}
+
+	id, err := s.Client.OpenScanner([]byte(tableName), scan)
+	if err != nil {
+		return err
+	}
+	defer s.Client.CloseScanner(id)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		results, err := s.Client.GetScannerRows(id, caching)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		for _, r := range results {
+			if err = fn(fromTResult(r)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/*
+fromTResult converts a thrift2 result
+*/
+func fromTResult(r *hbase2.TResult_) *Row {
+	row := &Row{Key: r.Row, Cells: make([]*Cell, 0, len(r.ColumnValues))}
+	for _, cv := range r.ColumnValues {
+		c := &Cell{Family: string(cv.Family), Qualifier: string(cv.Qualifier), Value: cv.Value}
+		if cv.Timestamp != nil {
+			c.Timestamp = *cv.Timestamp
+		}
+		row.Cells = append(row.Cells, c)
+	}
+	sortCells(row.Cells)
+	return row
+}
+
+/*
+WatermarkStore keeps the timestamp a ChangePoller has read up to
+*/
+type WatermarkStore interface {
+	Load() (int64, error) // 0 when nothing was saved
+	Save(watermark int64) error
+}
+
+/*
+FileWatermark stores the watermark as text in a local file
+*/
+type FileWatermark string
+
+/*
+Load reads the file, 0 when it does not exist
+*/
+func (f FileWatermark) Load() (int64, error) {
+	data, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+/*
+Save replaces the file
+*/
+func (f FileWatermark) Save(watermark int64) error {
+	tmp := string(f) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(watermark, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, string(f))
+}
+
+/*
+RowWatermark stores the watermark as text in a cell of a table, so
+pollers on other hosts can take over
+*/
+type RowWatermark struct {
+	Client *HClient
+	Table  string
+	Row    []byte
+	Column string // family:qualifier
+}
+
+/*
+Load reads the cell, 0 when it does not exist
+*/
+func (w *RowWatermark) Load() (int64, error) {
+	cells, err := w.Client.ReadCells(w.Table, w.Row, w.Column, nil)
+	if err != nil || len(cells) == 0 {
+		return 0, err
+	}
+	return strconv.ParseInt(string(cells[0].Value), 10, 64)
+}
+
+/*
+Save writes the cell
+*/
+func (w *RowWatermark) Save(watermark int64) error {
+	m := NewRowMutation(w.Row).Put(w.Column, []byte(strconv.FormatInt(watermark, 10)))
+	return w.Client.sendRowMutation(w.Table, m, nil)
+}
+
+/*
+PollerConfig tells how often a ChangePoller polls and where it keeps its
+watermark
+*/
+type PollerConfig struct {
+	Columns  []string      // families or family:qualifier columns, all when empty
+	Interval time.Duration // between polls, 10s by default
+
+	// Overlap is read again behind the watermark on each poll, for the
+	// cells written with a region server clock running behind. Cells
+	// already handled in it are skipped.
+	Overlap time.Duration // 1 minute by default, negative for none
+
+	Watermark WatermarkStore // nil keeps it in memory only
+	StartTime int64          // watermark (ms) when the store has none, the current time by default
+}
+
+/*
+ChangePoller reads the cells written to a table since its watermark and
+passes them to a handler, row by row. Delivery is at least once: cells
+handled just before a restart may be handled again.
+*/
+type ChangePoller struct {
+	source  ChangeSource
+	table   string
+	conf    PollerConfig
+	handler func(row *Row) error
+
+	mu        sync.Mutex
+	watermark int64
+	loaded    bool
+	seen      map[string]int64 // cells handled in the overlap window, by key
+}
+
+/*
+NewChangePoller return a poller of the table, handler gets the changed
+cells of each row
+*/
+func NewChangePoller(source ChangeSource, tableName string, conf PollerConfig, handler func(row *Row) error) *ChangePoller {
+	if conf.Interval <= 0 {
+		conf.Interval = 10 * time.Second
+	}
+	if conf.Overlap == 0 {
+		conf.Overlap = time.Minute
+	}
+	if conf.Overlap < 0 {
+		conf.Overlap = 0
+	}
+	return &ChangePoller{
+		source:  source,
+		table:   tableName,
+		conf:    conf,
+		handler: handler,
+		seen:    make(map[string]int64),
+	}
+}
+
+/*
+Watermark return the timestamp (ms) the poller has read up to
+*/
+func (p *ChangePoller) Watermark() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.watermark
+}
+
+func (p *ChangePoller) load() error {
+	if p.loaded {
+		return nil
+	}
+	if p.conf.Watermark != nil {
+		w, err := p.conf.Watermark.Load()
+		if err != nil {
+			return err
+		}
+		p.watermark = w
+	}
+	if p.watermark == 0 {
+		p.watermark = p.conf.StartTime
+	}
+	if p.watermark == 0 {
+		p.watermark = time.Now().UnixMilli()
+	}
+	p.loaded = true
+	return nil
+}
+
+func cellKey(row []byte, c *Cell) string {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(c.Timestamp))
+	return string(row) + "\x00" + c.Column() + "\x00" + string(ts[:])
+}
+
+/*
+Poll reads the changes once and return the number of cells handled. The
+watermark moves only when every row was handled.
+*/
+func (p *ChangePoller) Poll(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.load(); err != nil {
+		return 0, err
+	}
+
+	minStamp := p.watermark - p.conf.Overlap.Milliseconds()
+	if minStamp < 0 {
+		minStamp = 0
+	}
+	maxStamp := time.Now().UnixMilli()
+	if maxStamp <= p.watermark {
+		return 0, nil
+	}
+
+	handled := 0
+	err := p.source.ScanChanges(ctx, p.table, p.conf.Columns, minStamp, maxStamp, func(row *Row) error {
+		var keys []string
+		cells := row.Cells[:0]
+		for _, c := range row.Cells {
+			key := cellKey(row.Key, c)
+			if _, ok := p.seen[key]; ok {
+				continue
+			}
+			keys = append(keys, key)
+			cells = append(cells, c)
+		}
+		if len(cells) == 0 {
+			return nil
+		}
+
+		row.Cells = cells
+		if err := p.handler(row); err != nil {
+			return err
+		}
+		for i, key := range keys {
+			p.seen[key] = cells[i].Timestamp
+		}
+		handled += len(cells)
+		return nil
+	})
+	if err != nil {
+		return handled, err
+	}
+
+	if p.conf.Watermark != nil {
+		if err = p.conf.Watermark.Save(maxStamp); err != nil {
+			return handled, err
+		}
+	}
+	p.watermark = maxStamp
+
+	// cells below the next window are not read again
+	low := maxStamp - p.conf.Overlap.Milliseconds()
+	for key, ts := range p.seen {
+		if ts < low {
+			delete(p.seen, key)
+		}
+	}
+	return handled, nil
+}
+
+/*
+Run polls every Interval until ctx is done or a poll fails
+*/
+func (p *ChangePoller) Run(ctx context.Context) error {
+	t := time.NewTicker(p.conf.Interval)
+	defer t.Stop()
+	for {
+		if _, err := p.Poll(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
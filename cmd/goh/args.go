@@ -0,0 +1,280 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+cmdArgs are the arguments of a shell command: strings, lists of strings
+in brackets and options in braces, {KEY => value}
+*/
+type cmdArgs struct {
+	pos   []interface{} // string, []interface{} or map[string]interface{}
+	usage string
+}
+
+func (a *cmdArgs) usageError() error {
+	return errors.New("usage: " + a.usage)
+}
+
+func (a *cmdArgs) str(i int) (string, error) {
+	if i >= len(a.pos) {
+		return "", a.usageError()
+	}
+	s, ok := a.pos[i].(string)
+	if !ok {
+		return "", a.usageError()
+	}
+	return s, nil
+}
+
+func (a *cmdArgs) int(i int) (int64, error) {
+	s, err := a.str(i)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+/*
+strs return the string arguments from i on, up to the options
+*/
+func (a *cmdArgs) strs(i int) ([]string, error) {
+	var list []string
+	for ; i < len(a.pos); i++ {
+		switch v := a.pos[i].(type) {
+		case string:
+			list = append(list, v)
+		case []interface{}:
+			list = append(list, toStrings(v)...)
+		case map[string]interface{}:
+			return list, nil
+		}
+	}
+	return list, nil
+}
+
+/*
+opts return the options of the last argument, empty when there are none
+*/
+func (a *cmdArgs) opts() map[string]interface{} {
+	if n := len(a.pos); n > 0 {
+		if m, ok := a.pos[n-1].(map[string]interface{}); ok {
+			return m
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if l, ok := v.([]interface{}); ok && len(l) == 1 {
+		return toString(l[0])
+	}
+	return fmt.Sprint(v)
+}
+
+func toStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, toString(e))
+		}
+		return list
+	}
+	return nil
+}
+
+func optInt(opts map[string]interface{}, key string) (int64, error) {
+	v, ok := opts[key]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(toString(v), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", key, err)
+	}
+	return n, nil
+}
+
+type argParser struct {
+	src string
+	i   int
+}
+
+/*
+parseCommand splits a line in a command name and its arguments
+*/
+func parseCommand(line string) (string, *cmdArgs, error) {
+	p := &argParser{src: line}
+	p.skip()
+	name := p.word()
+	if name == "" {
+		return "", nil, errors.New("expected a command")
+	}
+
+	a := &cmdArgs{}
+	for {
+		p.skip()
+		if p.i >= len(p.src) {
+			return name, a, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return "", nil, err
+		}
+		a.pos = append(a.pos, v)
+	}
+}
+
+/*
+skip skips blanks and commas
+*/
+func (p *argParser) skip() {
+	for p.i < len(p.src) && strings.IndexByte(" \t,", p.src[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *argParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *argParser) word() string {
+	start := p.i
+	for p.i < len(p.src) && strings.IndexByte(" \t,{}[]'\"", p.src[p.i]) < 0 &&
+		!strings.HasPrefix(p.src[p.i:], "=>") {
+		p.i++
+	}
+	return p.src[start:p.i]
+}
+
+func (p *argParser) value() (interface{}, error) {
+	switch c := p.src[p.i]; c {
+	case '\'', '"':
+		return p.quoted(c)
+	case '[':
+		p.i++
+		var list []interface{}
+		for {
+			p.skip()
+			if p.i >= len(p.src) {
+				return nil, p.errorf("unterminated list")
+			}
+			if p.src[p.i] == ']' {
+				p.i++
+				return list, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case '{':
+		p.i++
+		opts := make(map[string]interface{})
+		for {
+			p.skip()
+			if p.i >= len(p.src) {
+				return nil, p.errorf("unterminated options")
+			}
+			if p.src[p.i] == '}' {
+				p.i++
+				return opts, nil
+			}
+			key, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, p.errorf("expected an option name")
+			}
+			p.skip()
+			if !strings.HasPrefix(p.src[p.i:], "=>") {
+				return nil, p.errorf("expected => after %s", k)
+			}
+			p.i += 2
+			p.skip()
+			if p.i >= len(p.src) {
+				return nil, p.errorf("expected a value for %s", k)
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			opts[strings.ToUpper(k)] = v
+		}
+	case ']', '}':
+		return nil, p.errorf("unexpected %q", c)
+	}
+
+	w := p.word()
+	if w == "" {
+		return nil, p.errorf("unexpected %q", p.src[p.i:p.i+1])
+	}
+	return w, nil
+}
+
+/*
+quoted reads a quoted string, a backslash escapes the quote; double
+quoted strings also take \xNN escapes
+*/
+func (p *argParser) quoted(q byte) (interface{}, error) {
+	start := p.i
+	p.i++
+	var sb strings.Builder
+	for {
+		if p.i >= len(p.src) {
+			p.i = start
+			return nil, p.errorf("unterminated string")
+		}
+		c := p.src[p.i]
+		if c == '\\' && p.i+1 < len(p.src) && p.src[p.i+1] == q {
+			sb.WriteByte(q)
+			p.i += 2
+			continue
+		}
+		p.i++
+		if c == q {
+			break
+		}
+		sb.WriteByte(c)
+	}
+
+	if q == '\'' {
+		return sb.String(), nil
+	}
+	b, err := parseBinary(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+/*
+splitList splits a comma separated flag value, leaving out empty items
+*/
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
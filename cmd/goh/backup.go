@@ -0,0 +1,139 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/backup"
+)
+
+func init() {
+	register(&command{name: "backup", usage: "full or incremental backup of a table to a directory", run: runBackup})
+	register(&command{name: "restore", usage: "restore a backup directory up to a point in time", run: runRestore})
+}
+
+/*
+parseTime reads milliseconds since the epoch or an RFC 3339 time
+*/
+func parseTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, errors.New("expected milliseconds or an RFC 3339 time")
+	}
+	return t.UnixMilli(), nil
+}
+
+func formatTime(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.UnixMilli(ms).Format(time.RFC3339)
+}
+
+func runBackup(client *goh.HClient, args []string) error {
+	var table, dir, columns string
+	var list bool
+	var opts backup.Options
+
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.StringVar(&table, "table", "", "table to back up")
+	fs.StringVar(&dir, "dir", "", "backup directory")
+	fs.BoolVar(&opts.Full, "full", false, "start a new chain with a full backup")
+	fs.StringVar(&columns, "columns", "", "comma separated families or family:qualifier columns")
+	fs.IntVar(&opts.Versions, "versions", 1, "versions of each column")
+	fs.BoolVar(&list, "list", false, "list the backups of the directory")
+	fs.Parse(args)
+
+	if dir == "" {
+		return errors.New("-dir is required")
+	}
+	if list {
+		return listBackups(dir)
+	}
+	if table == "" {
+		return errors.New("-table is required")
+	}
+	opts.Columns = splitList(columns)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	e, err := backup.Run(ctx, client, table, dir, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d rows, %d cells in %s\n", e.File, e.Rows, e.Cells, elapsed(start))
+	return nil
+}
+
+func listBackups(dir string) error {
+	m, err := backup.LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Println("table", m.Table)
+	for _, e := range m.Entries {
+		fmt.Printf("%-32s %-25s %-25s %10d rows %10d cells\n", e.File, formatTime(e.Since), formatTime(e.Until), e.Rows, e.Cells)
+	}
+	return nil
+}
+
+func runRestore(client *goh.HClient, args []string) error {
+	var table, dir, until string
+	var opts backup.RestoreOptions
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", "", "backup directory")
+	fs.StringVar(&table, "table", "", "table to write, the backed up table by default")
+	fs.StringVar(&until, "until", "", "point in time, milliseconds or RFC 3339, the latest backup by default")
+	fs.BoolVar(&opts.Create, "create", false, "create the table when it does not exist")
+	fs.IntVar(&opts.BatchRows, "batch", 1000, "rows per MutateRowsTs call")
+	fs.Float64Var(&opts.RowsPerSec, "rate", 0, "rows per second, 0 for no limit")
+	fs.Parse(args)
+
+	if dir == "" {
+		return errors.New("-dir is required")
+	}
+	var err error
+	if opts.Until, err = parseTime(until); err != nil {
+		return fmt.Errorf("-until: %v", err)
+	}
+	if table == "" {
+		m, err := backup.LoadManifest(dir)
+		if err != nil {
+			return err
+		}
+		table = m.Table
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	res, err := backup.Restore(ctx, client, dir, table, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "restored %d backups, %d rows, %d cells to %s in %s\n",
+		len(res.Entries), res.Rows, res.Cells, table, elapsed(start))
+	return nil
+}
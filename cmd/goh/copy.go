@@ -0,0 +1,107 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/chenjingping/goh"
+)
+
+func init() {
+	register(&command{name: "copy", usage: "copy a table to another cluster, resumable", run: runCopy})
+}
+
+/*
+parseMapping parses from=to pairs separated by commas, to may be empty
+*/
+func parseMapping(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("expected from=to, got %q", pair)
+		}
+		m[from] = to
+	}
+	return m, nil
+}
+
+func runCopy(client *goh.HClient, args []string) error {
+	var table, to, toTable, start, stop, columns, families, renames, checkpoint string
+	var conf goh.CopyConfig
+
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	fs.StringVar(&table, "table", "", "table to copy")
+	fs.StringVar(&to, "to", "", "destination gateway, host[:port]")
+	fs.StringVar(&toTable, "to-table", "", "destination table, the source name by default")
+	fs.StringVar(&start, "start", "", "first row, \\xNN escapes allowed")
+	fs.StringVar(&stop, "stop", "", "row to stop before")
+	fs.StringVar(&columns, "columns", "", "comma separated families or family:qualifier columns")
+	fs.Int64Var(&conf.StartTime, "start-time", 0, "leave out cells older than this timestamp (ms)")
+	fs.Int64Var(&conf.EndTime, "end-time", 0, "leave out cells at this timestamp (ms) or newer")
+	fs.StringVar(&families, "family", "", "family renames, from=to,... an empty to leaves the family out")
+	fs.StringVar(&renames, "column", "", "column renames, cf:a=cf:b,...")
+	fs.BoolVar(&conf.ServerTimestamps, "server-timestamps", false, "write at the destination server time")
+	fs.IntVar(&conf.Parallel, "parallel", 4, "regions copied at once")
+	fs.IntVar(&conf.BatchRows, "batch", 1000, "rows per write and checkpoint")
+	fs.Float64Var(&conf.RowsPerSec, "rate", 0, "rows per second over all regions, 0 for no limit")
+	fs.StringVar(&checkpoint, "checkpoint", "", "checkpoint file, an interrupted copy resumes from it")
+	fs.DurationVar(&conf.ProgressInterval, "progress", 10*time.Second, "progress report interval")
+	fs.Parse(args)
+
+	if table == "" || to == "" {
+		return errors.New("-table and -to are required")
+	}
+	if toTable == "" {
+		toTable = table
+	}
+
+	var err error
+	if conf.Range.Start, err = parseBinary(start); err != nil {
+		return fmt.Errorf("-start: %v", err)
+	}
+	if conf.Range.Stop, err = parseBinary(stop); err != nil {
+		return fmt.Errorf("-stop: %v", err)
+	}
+	conf.Columns = splitList(columns)
+	if conf.FamilyMap, err = parseMapping(families); err != nil {
+		return fmt.Errorf("-family: %v", err)
+	}
+	if conf.ColumnMap, err = parseMapping(renames); err != nil {
+		return fmt.Errorf("-column: %v", err)
+	}
+	conf.Checkpoint = checkpoint
+	conf.Progress = func(p goh.CopyProgress) {
+		fmt.Fprintf(os.Stderr, "%d/%d regions, %d rows, %d cells, %.0f rows/s\n",
+			p.RangesDone, p.Ranges, p.Rows, p.Cells, float64(p.Rows)/p.Elapsed.Seconds())
+	}
+
+	src := goh.NewPool(conf.Parallel, func() (*goh.HClient, error) { return conn.dial("") })
+	defer src.Close()
+	dst := goh.NewPool(conf.Parallel, func() (*goh.HClient, error) { return conn.dial(to) })
+	defer dst.Close()
+
+	// ^C stops the copy after saving the checkpoint
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stopSignals()
+
+	_, err = goh.Copy(ctx, src, dst, table, toTable, conf)
+	if err == context.Canceled && checkpoint != "" {
+		return fmt.Errorf("interrupted, run again with -checkpoint %s to resume", checkpoint)
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/filter"
+)
+
+func init() {
+	register(&command{name: "count", usage: "count the rows of a table with parallel scans", run: runCount})
+	register(&command{name: "agg", usage: "count, sum, min, max, avg and distinct values of a column", run: runAgg})
+}
+
+/*
+numberDecoders read the numbers of the agg command, text as stored by
+PutTyped or big endian as stored by the java Bytes.toBytes
+*/
+var numberDecoders = map[string]func(b []byte) (float64, error){
+	"text": func(b []byte) (float64, error) {
+		return strconv.ParseFloat(string(b), 64)
+	},
+	"long": func(b []byte) (float64, error) {
+		if len(b) != 8 {
+			return 0, errors.New("not 8 bytes")
+		}
+		return float64(int64(binary.BigEndian.Uint64(b))), nil
+	},
+	"int": func(b []byte) (float64, error) {
+		if len(b) != 4 {
+			return 0, errors.New("not 4 bytes")
+		}
+		return float64(int32(binary.BigEndian.Uint32(b))), nil
+	},
+	"double": func(b []byte) (float64, error) {
+		if len(b) != 8 {
+			return 0, errors.New("not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	},
+}
+
+/*
+aggFlags are the flags shared by count and agg
+*/
+type aggFlags struct {
+	table    string
+	start    string
+	stop     string
+	filter   string
+	quiet    bool
+	parallel int
+	interval time.Duration
+}
+
+func (f *aggFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.table, "table", "", "table to read")
+	fs.StringVar(&f.start, "start", "", "first row, \\xNN escapes allowed")
+	fs.StringVar(&f.stop, "stop", "", "row to stop before")
+	fs.StringVar(&f.filter, "filter", "", "server side filter, e.g. \"PrefixFilter('u1')\"")
+	fs.IntVar(&f.parallel, "parallel", 4, "regions scanned at once")
+	fs.DurationVar(&f.interval, "progress", 10*time.Second, "progress report interval")
+	fs.BoolVar(&f.quiet, "q", false, "no progress reports")
+}
+
+func (f *aggFlags) config() (goh.AggregateConfig, error) {
+	conf := goh.AggregateConfig{Parallel: f.parallel, ProgressInterval: f.interval}
+	if f.table == "" {
+		return conf, errors.New("-table is required")
+	}
+
+	var err error
+	if conf.Range.Start, err = parseBinary(f.start); err != nil {
+		return conf, fmt.Errorf("-start: %v", err)
+	}
+	if conf.Range.Stop, err = parseBinary(f.stop); err != nil {
+		return conf, fmt.Errorf("-stop: %v", err)
+	}
+	if f.filter != "" {
+		if conf.Filter, err = filter.Parse(f.filter); err != nil {
+			return conf, fmt.Errorf("-filter: %v", err)
+		}
+	}
+	if !f.quiet {
+		conf.Progress = func(p goh.ScanProgress) {
+			fmt.Fprintf(os.Stderr, "%d/%d regions, %d rows, %s\n",
+				p.RangesDone, p.Ranges, p.Rows, p.Elapsed.Round(time.Second))
+		}
+	}
+	return conf, nil
+}
+
+func (f *aggFlags) pool() *goh.Pool {
+	return goh.NewPool(f.parallel, func() (*goh.HClient, error) { return conn.dial("") })
+}
+
+func runCount(client *goh.HClient, args []string) error {
+	var f aggFlags
+	fs := flag.NewFlagSet("count", flag.ExitOnError)
+	f.register(fs)
+	fs.Parse(args)
+
+	conf, err := f.config()
+	if err != nil {
+		return err
+	}
+	pool := f.pool()
+	defer pool.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	n, err := goh.Count(ctx, pool, f.table, conf)
+	if err != nil {
+		return err
+	}
+	fmt.Println(n)
+	return nil
+}
+
+func runAgg(client *goh.HClient, args []string) error {
+	var f aggFlags
+	var column, decode string
+	fs := flag.NewFlagSet("agg", flag.ExitOnError)
+	f.register(fs)
+	fs.StringVar(&column, "column", "", "family:qualifier column")
+	fs.StringVar(&decode, "decode", "text", "number encoding: "+numberDecoderNames())
+	fs.Parse(args)
+
+	conf, err := f.config()
+	if err != nil {
+		return err
+	}
+	if column == "" {
+		return errors.New("-column is required")
+	}
+	if conf.Decode = numberDecoders[decode]; conf.Decode == nil {
+		return fmt.Errorf("unknown -decode %q, one of %s", decode, numberDecoderNames())
+	}
+	pool := f.pool()
+	defer pool.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a, err := goh.Aggregate(ctx, pool, f.table, column, conf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rows      %d\n", a.Rows)
+	fmt.Printf("count     %d\n", a.Count)
+	if a.Skipped > 0 {
+		fmt.Printf("skipped   %d (not numbers)\n", a.Skipped)
+	}
+	if a.Count > 0 {
+		fmt.Printf("sum       %g\n", a.Sum)
+		fmt.Printf("min       %g\n", a.Min)
+		fmt.Printf("max       %g\n", a.Max)
+		fmt.Printf("avg       %g\n", a.Avg())
+	}
+	fmt.Printf("distinct ~%d\n", a.Distinct.Count())
+	return nil
+}
+
+func numberDecoderNames() string {
+	names := make([]string, 0, len(numberDecoders))
+	for name := range numberDecoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,144 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+decoders print cell values and row keys
+*/
+var decoders = map[string]func(b []byte) string{
+	"binary": toStringBinary,
+	"utf8":   toStringUTF8,
+	"hex":    hex.EncodeToString,
+	"base64": base64.StdEncoding.EncodeToString,
+	"int":    toStringInt,
+}
+
+func decoderNames() string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+/*
+toStringBinary prints printable ASCII as is and other bytes as \xNN, like
+Bytes.toStringBinary of the hbase shell
+*/
+func toStringBinary(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c >= ' ' && c < 0x7f && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "\\x%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+/*
+toStringUTF8 prints valid UTF-8 as text with control characters escaped,
+other values as binary
+*/
+func toStringUTF8(b []byte) string {
+	if !utf8.Valid(b) {
+		return toStringBinary(b)
+	}
+	s := strconv.Quote(string(b))
+	return s[1 : len(s)-1]
+}
+
+/*
+toStringInt prints 8 and 4 byte values as big endian integers, as
+written by Bytes.toBytes(long) and Bytes.toBytes(int)
+*/
+func toStringInt(b []byte) string {
+	switch len(b) {
+	case 8:
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(b)), 10)
+	case 4:
+		return strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(b))), 10)
+	}
+	return toStringBinary(b)
+}
+
+/*
+parseBinary reads \xNN escapes, the inverse of toStringBinary
+*/
+func parseBinary(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+3 < len(s) && s[i+1] == 'x' {
+			v, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+			if err == nil {
+				b = append(b, byte(v))
+				i += 3
+				continue
+			}
+		}
+		if i+1 < len(s) && s[i+1] == '\\' {
+			b = append(b, '\\')
+			i++
+			continue
+		}
+		return nil, fmt.Errorf("invalid escape at offset %d in %q", i, s)
+	}
+	return b, nil
+}
+
+/*
+encoding turns keys and values into text and back for export files
+*/
+type encoding struct {
+	encode func(b []byte) (string, error)
+	decode func(s string) ([]byte, error)
+}
+
+var encodings = map[string]encoding{
+	"base64": {
+		encode: func(b []byte) (string, error) { return base64.StdEncoding.EncodeToString(b), nil },
+		decode: base64.StdEncoding.DecodeString,
+	},
+	"hex": {
+		encode: func(b []byte) (string, error) { return hex.EncodeToString(b), nil },
+		decode: hex.DecodeString,
+	},
+	"utf8": {
+		encode: func(b []byte) (string, error) {
+			if !utf8.Valid(b) {
+				return "", fmt.Errorf("value %s is not valid UTF-8, use base64 or hex", toStringBinary(b))
+			}
+			return string(b), nil
+		},
+		decode: func(s string) ([]byte, error) { return []byte(s), nil },
+	},
+}
+
+func encodingNames() string {
+	names := make([]string, 0, len(encodings))
+	for name := range encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
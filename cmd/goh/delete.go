@@ -0,0 +1,70 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/chenjingping/goh"
+)
+
+func init() {
+	register(&command{name: "delete", usage: "delete the rows of a key range, prefix or filter", run: runDelete})
+}
+
+func runDelete(client *goh.HClient, args []string) error {
+	var f aggFlags
+	var prefix, columns string
+	var yes bool
+	var conf goh.DeleteConfig
+
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	f.register(fs)
+	fs.StringVar(&prefix, "prefix", "", "rows starting with prefix, \\xNN escapes allowed")
+	fs.StringVar(&columns, "columns", "", "comma separated families or family:qualifier columns to delete, whole rows by default")
+	fs.BoolVar(&conf.RowByRow, "row-by-row", false, "delete with DeleteAllRow, one call per row")
+	fs.IntVar(&conf.BatchRows, "batch", 1000, "rows per MutateRows call")
+	fs.Float64Var(&conf.RowsPerSec, "rate", 0, "rows per second over all regions, 0 for no limit")
+	fs.BoolVar(&conf.DryRun, "dry-run", false, "count the rows, delete nothing")
+	fs.BoolVar(&yes, "yes", false, "delete without -dry-run")
+	fs.Parse(args)
+
+	ac, err := f.config()
+	if err != nil {
+		return err
+	}
+	if !conf.DryRun && !yes {
+		return errors.New("run with -dry-run to count the rows, then with -yes to delete them")
+	}
+	if conf.Prefix, err = parseBinary(prefix); err != nil {
+		return fmt.Errorf("-prefix: %v", err)
+	}
+	conf.Range = ac.Range
+	conf.Filter = ac.Filter
+	conf.Columns = splitList(columns)
+	conf.Parallel = ac.Parallel
+	conf.Progress = ac.Progress
+	conf.ProgressInterval = ac.ProgressInterval
+
+	pool := f.pool()
+	defer pool.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	n, err := goh.BulkDelete(ctx, pool, f.table, conf)
+	if conf.DryRun {
+		fmt.Printf("%d rows would be deleted\n", n)
+	} else {
+		fmt.Printf("%d rows deleted\n", n)
+	}
+	return err
+}
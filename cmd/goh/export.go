@@ -0,0 +1,239 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/filter"
+)
+
+func init() {
+	register(&command{name: "export", usage: "write a table to a JSON Lines or CSV file", run: runExport})
+	register(&command{name: "import", usage: "write a JSON Lines or CSV file to a table", run: runImport})
+}
+
+/*
+exportOptions select the rows, columns and versions of an export
+*/
+type exportOptions struct {
+	table     string
+	start     string
+	stop      string
+	columns   string
+	filter    string
+	startTime int64 // cells older than this are left out
+	endTime   int64 // cells at this time or newer are left out, 0 for none
+	versions  int
+}
+
+func (o *exportOptions) flags(fs *flag.FlagSet) {
+	fs.StringVar(&o.table, "table", "", "table to read")
+	fs.StringVar(&o.start, "start", "", "first row, \\xNN escapes allowed")
+	fs.StringVar(&o.stop, "stop", "", "row to stop before")
+	fs.StringVar(&o.columns, "columns", "", "comma separated families or family:qualifier columns")
+	fs.StringVar(&o.filter, "filter", "", "server side filter, e.g. \"PrefixFilter('u1')\"")
+	fs.Int64Var(&o.startTime, "start-time", 0, "leave out cells older than this timestamp (ms)")
+	fs.Int64Var(&o.endTime, "end-time", 0, "leave out cells at this timestamp (ms) or newer")
+	fs.IntVar(&o.versions, "versions", 1, "versions of each column")
+}
+
+func (o *exportOptions) scan() (*goh.TScan, error) {
+	if o.table == "" {
+		return nil, errors.New("-table is required")
+	}
+	if o.versions < 1 {
+		return nil, errors.New("-versions must be at least 1")
+	}
+
+	scan := &goh.TScan{Caching: 100, Timestamp: o.endTime}
+	var err error
+	if scan.StartRow, err = parseBinary(o.start); err != nil {
+		return nil, fmt.Errorf("-start: %v", err)
+	}
+	if scan.StopRow, err = parseBinary(o.stop); err != nil {
+		return nil, fmt.Errorf("-stop: %v", err)
+	}
+	scan.Columns = splitList(o.columns)
+	if o.filter != "" {
+		f, err := filter.Parse(o.filter)
+		if err != nil {
+			return nil, fmt.Errorf("-filter: %v", err)
+		}
+		scan.SetFilter(f)
+	}
+	return scan, nil
+}
+
+/*
+exportRows calls fn with the selected rows. thrift1 scans return the latest
+version of each column, older versions are read column by column.
+*/
+func exportRows(client *goh.HClient, o *exportOptions, fn func(row *goh.Row) error) error {
+	scan, err := o.scan()
+	if err != nil {
+		return err
+	}
+
+	return client.Table(o.table).Scan(scan, nil, func(row *goh.Row) error {
+		if o.versions > 1 {
+			var cells []*goh.Cell
+			for _, col := range row.Columns() {
+				var versions []*goh.Cell
+				if o.endTime > 0 {
+					versions, err = client.ReadVersionsTs(o.table, row.Key, col, o.endTime, int32(o.versions), nil)
+				} else {
+					versions, err = client.ReadVersions(o.table, row.Key, col, int32(o.versions), nil)
+				}
+				if err != nil {
+					return err
+				}
+				cells = append(cells, versions...)
+			}
+			row = &goh.Row{Key: row.Key, Cells: cells}
+		}
+
+		if o.startTime > 0 {
+			cells := row.Cells[:0]
+			for _, c := range row.Cells {
+				if c.Timestamp >= o.startTime {
+					cells = append(cells, c)
+				}
+			}
+			row.Cells = cells
+		}
+		if len(row.Cells) == 0 {
+			return nil
+		}
+		return fn(row)
+	})
+}
+
+func runExport(client *goh.HClient, args []string) error {
+	var o exportOptions
+	var output, format, enc string
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	o.flags(fs)
+	fs.StringVar(&output, "o", "-", "output file, - for stdout")
+	fs.StringVar(&format, "format", "jsonl", "jsonl or csv")
+	fs.StringVar(&enc, "encoding", "base64", "encoding of keys and values: "+encodingNames())
+	fs.Parse(args)
+
+	w := os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	rw, err := newRecordWriter(format, enc, w)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	start := time.Now()
+	var rows, cells int64
+	err = exportRows(client, &o, func(row *goh.Row) error {
+		rows++
+		cells += int64(len(row.Cells))
+		return rw.Write(row)
+	})
+	if e := rw.Flush(); err == nil {
+		err = e
+	}
+	if w != os.Stdout {
+		if e := w.Close(); err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d rows, %d cells in %s\n", rows, cells, elapsed(start))
+	return nil
+}
+
+func runImport(client *goh.HClient, args []string) error {
+	var table, input, format, enc string
+	var batch int
+	var rate float64
+	var wal, keepTs bool
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.StringVar(&table, "table", "", "table to write")
+	fs.StringVar(&input, "i", "-", "input file, - for stdin")
+	fs.StringVar(&format, "format", "jsonl", "jsonl or csv")
+	fs.StringVar(&enc, "encoding", "base64", "encoding of keys and values: "+encodingNames())
+	fs.IntVar(&batch, "batch", 1000, "rows per MutateRows call")
+	fs.Float64Var(&rate, "rate", 0, "rows per second, 0 for no limit")
+	fs.BoolVar(&wal, "wal", true, "write to the WAL")
+	fs.BoolVar(&keepTs, "keep-timestamps", true, "write the cells with their timestamps")
+	fs.Parse(args)
+
+	if table == "" {
+		return errors.New("-table is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	rr, err := newRecordReader(format, enc, r)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	bm := goh.NewBufferedMutator(client, table, goh.MutatorConfig{MaxRows: batch, RowsPerSec: rate})
+	for {
+		row, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = bm.Mutate(rowMutation(row, wal, keepTs))
+		}
+		if err != nil {
+			bm.Close()
+			return err
+		}
+	}
+	if err = bm.Close(); err != nil {
+		return err
+	}
+
+	stats := bm.Stats()
+	fmt.Fprintf(os.Stderr, "imported %d rows in %d batches, %s\n", stats.Rows, stats.Batches, elapsed(start))
+	return nil
+}
+
+/*
+rowMutation puts the cells of the row
+*/
+func rowMutation(row *goh.Row, wal, keepTimestamps bool) *goh.RowMutation {
+	m := goh.NewRowMutation(row.Key).SetWriteToWAL(wal)
+	for _, c := range row.Cells {
+		if keepTimestamps {
+			m.Timestamp(c.Timestamp)
+		}
+		m.Put(c.Column(), c.Value)
+	}
+	return m
+}
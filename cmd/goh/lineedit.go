@@ -0,0 +1,305 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+errInterrupt is returned by readLine when the line is abandoned with ^C
+*/
+var errInterrupt = errors.New("interrupted")
+
+const maxHistory = 1000
+
+/*
+lineEditor reads lines with emacs style editing, history and completion
+when the input is a terminal, plain lines otherwise
+*/
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	history  []string
+	histFile string
+
+	// complete return the word ending the text before the cursor and the
+	// words which may replace it
+	complete func(before string) (word string, candidates []string)
+}
+
+func newLineEditor(histFile string) *lineEditor {
+	e := &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		fd:       int(os.Stdin.Fd()),
+		histFile: histFile,
+	}
+	e.loadHistory()
+	return e
+}
+
+/*
+interactive reports whether lines are edited on a terminal
+*/
+func (e *lineEditor) interactive() bool {
+	return isTerminal(e.fd)
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.histFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.histFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+/*
+readLine reads one line, io.EOF at the end of the input or on ^D
+*/
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !e.interactive() {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+
+	line, err := e.edit(prompt)
+	if err == nil {
+		e.addHistory(line)
+	}
+	return line, err
+}
+
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	hist := len(e.history)
+	current := ""
+	lastTab := false
+
+	refresh := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	insert := func(rs []rune) {
+		buf = append(buf[:pos], append(rs, buf[pos:]...)...)
+		pos += len(rs)
+	}
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+	recall := func(delta int) {
+		h := hist + delta
+		if h < 0 || h > len(e.history) {
+			return
+		}
+		if hist == len(e.history) {
+			current = string(buf)
+		}
+		hist = h
+		if hist == len(e.history) {
+			setLine(current)
+		} else {
+			setLine(e.history[hist])
+		}
+	}
+
+	refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		tab := false
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // ^C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // ^D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // ^A
+			pos = 0
+		case 5: // ^E
+			pos = len(buf)
+		case 2: // ^B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // ^F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // ^K
+			buf = buf[:pos]
+		case 21: // ^U
+			buf = append([]rune{}, buf[pos:]...)
+			pos = 0
+		case 23: // ^W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // ^L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // ^P
+			recall(-1)
+		case 14: // ^N
+			recall(1)
+		case 127, 8: // backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case '\t':
+			tab = true
+			if e.complete == nil {
+				break
+			}
+			word, candidates := e.complete(string(buf[:pos]))
+			switch {
+			case len(candidates) == 0:
+				fmt.Fprint(e.out, "\a")
+			case len(candidates) == 1:
+				insert([]rune(candidates[0][len(word):]))
+			default:
+				if common := commonPrefix(candidates); len(common) > len(word) {
+					insert([]rune(common[len(word):]))
+				} else if lastTab {
+					fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+				} else {
+					fmt.Fprint(e.out, "\a")
+				}
+			}
+		case 27: // escape sequence
+			e.escape(&buf, &pos, recall)
+		default:
+			if r >= ' ' {
+				insert([]rune{r})
+			}
+		}
+		lastTab = tab
+		refresh()
+	}
+}
+
+/*
+escape handles the arrow, home, end and delete keys
+*/
+func (e *lineEditor) escape(buf *[]rune, pos *int, recall func(int)) {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+	seq := ""
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return
+		}
+		seq += string(c)
+		if c >= 0x40 && c <= 0x7e {
+			break
+		}
+	}
+
+	switch seq {
+	case "A":
+		recall(-1)
+	case "B":
+		recall(1)
+	case "C":
+		if *pos < len(*buf) {
+			*pos++
+		}
+	case "D":
+		if *pos > 0 {
+			*pos--
+		}
+	case "H", "1~", "7~":
+		*pos = 0
+	case "F", "4~", "8~":
+		*pos = len(*buf)
+	case "3~":
+		if *pos < len(*buf) {
+			*buf = append((*buf)[:*pos], (*buf)[*pos+1:]...)
+		}
+	}
+}
+
+func commonPrefix(words []string) string {
+	p := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, p) {
+			p = p[:len(p)-1]
+		}
+	}
+	return p
+}
@@ -0,0 +1,153 @@
+/*
+Command goh is a shell and a set of tools for HBase over the thrift1
+gateway.
+
+	goh [-h host] [-p port] [-P protocol] [-framed] [-u url] [command [args]]
+
+Without a command it starts the interactive shell, "goh help" lists the
+commands.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/chenjingping/goh"
+)
+
+/*
+command is a subcommand of goh
+*/
+type command struct {
+	name  string
+	usage string // one line summary
+	run   func(client *goh.HClient, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func register(c *command) {
+	commands[c.name] = c
+}
+
+var protocols = map[string]int{
+	"binary":     goh.TBinaryProtocol,
+	"compact":    goh.TCompactProtocol,
+	"json":       goh.TJSONProtocol,
+	"simplejson": goh.TSimpleJSONProtocol,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage of", os.Args[0], "[-h host] [-p port] [-P protocol] [-framed] [-u url] [command [args]]:")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun", os.Args[0], "command -help for the options of a command.")
+}
+
+/*
+connection holds the flags choosing the gateway, commands working in
+parallel dial more clients with it
+*/
+type connection struct {
+	host     string
+	port     string
+	protocol int
+	framed   bool
+	url      string
+}
+
+var conn connection
+
+/*
+dial return an opened client of the gateway, or of host[:port] when host
+is set
+*/
+func (c *connection) dial(host string) (*goh.HClient, error) {
+	var client *goh.HClient
+	var err error
+	if c.url != "" && host == "" {
+		client, err = goh.NewHTTPClient(c.url, c.protocol)
+	} else {
+		port := c.port
+		if host == "" {
+			host = c.host
+		}
+		if h, p, e := net.SplitHostPort(host); e == nil {
+			host, port = h, p
+		}
+		client, err = goh.NewTCPClient(host, port, c.protocol, c.framed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = client.Open(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func main() {
+	var port int
+	var protocol string
+
+	flag.Usage = usage
+	flag.StringVar(&conn.host, "h", "localhost", "Specify host, or host:port")
+	flag.IntVar(&port, "p", 9090, "Specify port")
+	flag.StringVar(&protocol, "P", "binary", "Specify the protocol (binary, compact, simplejson, json)")
+	flag.StringVar(&conn.url, "u", "", "Specify the url of an http gateway")
+	flag.BoolVar(&conn.framed, "framed", false, "Use framed transport")
+	flag.Parse()
+
+	name := flag.Arg(0)
+	if name == "" {
+		name = "shell"
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command:", name)
+		usage()
+		os.Exit(2)
+	}
+
+	if conn.protocol, ok = protocols[protocol]; !ok {
+		fmt.Fprintln(os.Stderr, "invalid protocol:", protocol)
+		os.Exit(2)
+	}
+	conn.port = strconv.Itoa(port)
+
+	client, err := conn.dial("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error connecting:", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	args := flag.Args()
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	if err = cmd.run(client, args); err != nil {
+		fmt.Fprintln(os.Stderr, name+":", err)
+		client.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/chenjingping/goh"
+)
+
+/*
+Export files hold rows as JSON Lines, one row per line:
+
+	{"row":"dTE=","cells":[{"column":"aW5mbzpuYW1l","timestamp":1500000000000,"value":"Ym9i"}]}
+
+or as CSV, one cell per line after a header, the cells of a row on
+consecutive lines:
+
+	row,column,timestamp,value
+	dTE=,aW5mbzpuYW1l,1500000000000,Ym9i
+
+Keys, columns and values are written with the chosen encoding.
+*/
+
+var formats = []string{"jsonl", "csv"}
+
+type jsonCell struct {
+	Column    string `json:"column"`
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type jsonRow struct {
+	Row   string     `json:"row"`
+	Cells []jsonCell `json:"cells"`
+}
+
+type recordWriter interface {
+	Write(row *goh.Row) error
+	Flush() error
+}
+
+type recordReader interface {
+	Read() (*goh.Row, error) // io.EOF at the end
+}
+
+func newRecordWriter(format, enc string, w io.Writer) (recordWriter, error) {
+	e, ok := encodings[enc]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q, one of %s", enc, encodingNames())
+	}
+	switch format {
+	case "jsonl":
+		bw := bufio.NewWriter(w)
+		return &jsonWriter{bw: bw, enc: json.NewEncoder(bw), e: e}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		return &csvWriter{cw: cw, e: e}, cw.Write([]string{"row", "column", "timestamp", "value"})
+	}
+	return nil, fmt.Errorf("unknown format %q, one of %v", format, formats)
+}
+
+func newRecordReader(format, enc string, r io.Reader) (recordReader, error) {
+	e, ok := encodings[enc]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q, one of %s", enc, encodingNames())
+	}
+	switch format {
+	case "jsonl":
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64<<10), 256<<20)
+		return &jsonReader{sc: sc, e: e}, nil
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 4
+		cr.ReuseRecord = true
+		header, err := cr.Read()
+		if err == nil && header[0] != "row" {
+			err = fmt.Errorf("csv: expected header row,column,timestamp,value")
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return &csvReader{cr: cr, e: e}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, one of %v", format, formats)
+}
+
+type jsonWriter struct {
+	bw  *bufio.Writer
+	enc *json.Encoder
+	e   encoding
+}
+
+func (w *jsonWriter) Write(row *goh.Row) error {
+	key, err := w.e.encode(row.Key)
+	if err != nil {
+		return err
+	}
+	rec := jsonRow{Row: key, Cells: make([]jsonCell, len(row.Cells))}
+	for i, c := range row.Cells {
+		col, err := w.e.encode([]byte(c.Column()))
+		if err != nil {
+			return err
+		}
+		val, err := w.e.encode(c.Value)
+		if err != nil {
+			return err
+		}
+		rec.Cells[i] = jsonCell{Column: col, Timestamp: c.Timestamp, Value: val}
+	}
+	return w.enc.Encode(&rec)
+}
+
+func (w *jsonWriter) Flush() error {
+	return w.bw.Flush()
+}
+
+type jsonReader struct {
+	sc   *bufio.Scanner
+	e    encoding
+	line int
+}
+
+func (r *jsonReader) Read() (*goh.Row, error) {
+	for r.sc.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec jsonRow
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		row, err := decodeRecord(r.e, rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		return row, nil
+	}
+	if err := r.sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func decodeRecord(e encoding, rec jsonRow) (*goh.Row, error) {
+	key, err := e.decode(rec.Row)
+	if err != nil {
+		return nil, fmt.Errorf("row: %v", err)
+	}
+	row := &goh.Row{Key: key, Cells: make([]*goh.Cell, len(rec.Cells))}
+	for i, c := range rec.Cells {
+		if row.Cells[i], err = decodeCell(e, c.Column, c.Timestamp, c.Value); err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+func decodeCell(e encoding, column string, timestamp int64, value string) (*goh.Cell, error) {
+	col, err := e.decode(column)
+	if err != nil {
+		return nil, fmt.Errorf("column: %v", err)
+	}
+	val, err := e.decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("value: %v", err)
+	}
+	family, qualifier := goh.SplitColumn(string(col))
+	return &goh.Cell{Family: family, Qualifier: qualifier, Value: val, Timestamp: timestamp}, nil
+}
+
+type csvWriter struct {
+	cw *csv.Writer
+	e  encoding
+}
+
+func (w *csvWriter) Write(row *goh.Row) error {
+	key, err := w.e.encode(row.Key)
+	if err != nil {
+		return err
+	}
+	for _, c := range row.Cells {
+		col, err := w.e.encode([]byte(c.Column()))
+		if err != nil {
+			return err
+		}
+		val, err := w.e.encode(c.Value)
+		if err != nil {
+			return err
+		}
+		if err = w.cw.Write([]string{key, col, strconv.FormatInt(c.Timestamp, 10), val}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *csvWriter) Flush() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+type csvReader struct {
+	cr      *csv.Reader
+	e       encoding
+	pending []string // first line of the next row
+	done    bool
+}
+
+func (r *csvReader) next() ([]string, error) {
+	if r.pending != nil {
+		rec := r.pending
+		r.pending = nil
+		return rec, nil
+	}
+	rec, err := r.cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), rec...), nil
+}
+
+func (r *csvReader) Read() (*goh.Row, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	var row *goh.Row
+	var key string
+	for {
+		rec, err := r.next()
+		if err == io.EOF {
+			r.done = true
+			if row == nil {
+				return nil, io.EOF
+			}
+			return row, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if row != nil && rec[0] != key {
+			r.pending = rec
+			return row, nil
+		}
+		if row == nil {
+			key = rec[0]
+			k, err := r.e.decode(key)
+			if err != nil {
+				return nil, fmt.Errorf("row: %v", err)
+			}
+			row = &goh.Row{Key: k}
+		}
+
+		ts, err := strconv.ParseInt(rec[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp: %v", err)
+		}
+		c, err := decodeCell(r.e, rec[1], ts, rec[3])
+		if err != nil {
+			return nil, err
+		}
+		row.Cells = append(row.Cells, c)
+	}
+}
@@ -0,0 +1,747 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/filter"
+)
+
+func init() {
+	register(&command{name: "shell", usage: "interactive shell (default)", run: runShell})
+}
+
+/*
+shell runs hbase shell like commands on a client
+*/
+type shell struct {
+	client   *goh.HClient
+	out      io.Writer
+	decode   string
+	tables   []string            // completion cache, nil until loaded
+	families map[string][]string // completion cache
+}
+
+type shellCommand struct {
+	name  string
+	usage string
+	run   func(sh *shell, a *cmdArgs) error
+}
+
+var shellCommands []*shellCommand
+
+func init() {
+	shellCommands = []*shellCommand{
+		{"list", "list ['regex']", (*shell).list},
+		{"describe", "describe 't'", (*shell).describe},
+		{"create", "create 't', 'f1', {NAME => 'f2', VERSIONS => 5, TTL => 3600, COMPRESSION => 'SNAPPY', IN_MEMORY => true, BLOOMFILTER => 'ROW', BLOCKCACHE => true}", (*shell).create},
+		{"disable", "disable 't'", (*shell).disable},
+		{"enable", "enable 't'", (*shell).enable},
+		{"drop", "drop 't' (the table must be disabled)", (*shell).drop},
+		{"get", "get 't', 'row' [, 'f:q', ...] [, {COLUMNS => ['f:q'], TIMESTAMP => ts, VERSIONS => n}]", (*shell).get},
+		{"put", "put 't', 'row', 'f:q', 'value' [, ts]", (*shell).put},
+		{"delete", "delete 't', 'row', 'f:q' [, ts]", (*shell).delete},
+		{"deleteall", "deleteall 't', 'row' [, 'f:q']", (*shell).deleteAll},
+		{"scan", "scan 't' [, {STARTROW => 'a', STOPROW => 'b', ROWPREFIXFILTER => 'p', COLUMNS => ['f:q'], LIMIT => 10, FILTER => \"...\", TIMESTAMP => ts, CACHE => 100}]", (*shell).scan},
+		{"count", "count 't' [, {FILTER => \"...\", INTERVAL => 1000, CACHE => 1000}]", (*shell).count},
+		{"incr", "incr 't', 'row', 'f:q' [, n]", (*shell).incr},
+		{"decode", "decode [" + decoderNames() + "]", (*shell).setDecode},
+		{"help", "help ['command']", (*shell).help},
+	}
+}
+
+func runShell(client *goh.HClient, args []string) error {
+	sh := &shell{client: client, out: os.Stdout, decode: "binary"}
+
+	histFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		histFile = filepath.Join(home, ".goh_history")
+	}
+	ed := newLineEditor(histFile)
+	ed.complete = sh.complete
+
+	if ed.interactive() {
+		fmt.Fprintln(sh.out, "goh shell, type help for the commands, exit to leave")
+	}
+	for {
+		line, err := ed.readLine("goh> ")
+		if err == errInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err = sh.exec(line); err != nil {
+			fmt.Fprintln(sh.out, "ERROR:", err)
+		}
+	}
+}
+
+/*
+exec runs one command line
+*/
+func (sh *shell) exec(line string) error {
+	name, a, err := parseCommand(line)
+	if err != nil {
+		return err
+	}
+	for _, c := range shellCommands {
+		if c.name == name {
+			a.usage = c.usage
+			return c.run(sh, a)
+		}
+	}
+	return fmt.Errorf("unknown command %q, type help", name)
+}
+
+func (sh *shell) value(b []byte) string {
+	return decoders[sh.decode](b)
+}
+
+func elapsed(start time.Time) string {
+	return fmt.Sprintf("%.4f seconds", time.Since(start).Seconds())
+}
+
+/*
+commands
+*/
+
+func (sh *shell) list(a *cmdArgs) error {
+	var re *regexp.Regexp
+	if len(a.pos) > 0 {
+		pattern, err := a.str(0)
+		if err != nil {
+			return err
+		}
+		if re, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	names, err := sh.client.GetTableNames()
+	if err != nil {
+		return err
+	}
+	sh.tables = names
+
+	n := 0
+	fmt.Fprintln(sh.out, "TABLE")
+	for _, name := range names {
+		if re == nil || re.MatchString(name) {
+			fmt.Fprintln(sh.out, name)
+			n++
+		}
+	}
+	fmt.Fprintf(sh.out, "%d row(s) in %s\n", n, elapsed(start))
+	return nil
+}
+
+func (sh *shell) describe(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := sh.client.IsTableEnabled(table)
+	if err != nil {
+		return err
+	}
+	cols, err := sh.client.GetColumnDescriptors(table)
+	if err != nil {
+		return err
+	}
+	regions, err := sh.client.GetTableRegions(table)
+	if err != nil {
+		return err
+	}
+
+	state := "ENABLED"
+	if !enabled {
+		state = "DISABLED"
+	}
+	fmt.Fprintf(sh.out, "Table %s is %s, %d region(s)\n", table, state, len(regions))
+
+	names := make([]string, 0, len(cols))
+	for name := range cols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FAMILY\tVERSIONS\tCOMPRESSION\tBLOOMFILTER\tIN_MEMORY\tBLOCKCACHE\tTTL")
+	for _, name := range names {
+		c := cols[name]
+		ttl := strconv.Itoa(int(c.TimeToLive))
+		if c.TimeToLive <= 0 || c.TimeToLive == 1<<31-1 {
+			ttl = "FOREVER"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%t\t%t\t%s\n", strings.TrimSuffix(name, ":"),
+			c.MaxVersions, c.Compression, c.BloomFilterType, c.InMemory, c.BlockCacheEnabled, ttl)
+	}
+	return w.Flush()
+}
+
+func (sh *shell) create(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	if len(a.pos) < 2 {
+		return a.usageError()
+	}
+
+	var cols []*goh.ColumnDescriptor
+	for _, v := range a.pos[1:] {
+		switch v := v.(type) {
+		case string:
+			cols = append(cols, goh.NewColumnDescriptorDefault(v+":"))
+		case map[string]interface{}:
+			col, err := familyOptions(v)
+			if err != nil {
+				return err
+			}
+			cols = append(cols, col)
+		default:
+			return a.usageError()
+		}
+	}
+
+	start := time.Now()
+	if _, err = sh.client.CreateTable(table, cols); err != nil {
+		return err
+	}
+	sh.tables = nil
+	fmt.Fprintf(sh.out, "Created table %s in %s\n", table, elapsed(start))
+	return nil
+}
+
+func familyOptions(opts map[string]interface{}) (*goh.ColumnDescriptor, error) {
+	name, ok := opts["NAME"].(string)
+	if !ok {
+		return nil, errors.New("family without NAME")
+	}
+	col := goh.NewColumnDescriptorDefault(strings.TrimSuffix(name, ":") + ":")
+
+	for key, v := range opts {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a value", key)
+		}
+		var err error
+		switch key {
+		case "NAME":
+		case "VERSIONS":
+			col.MaxVersions, err = parseInt32(s)
+		case "TTL":
+			col.TimeToLive, err = parseInt32(s)
+		case "COMPRESSION":
+			col.Compression = strings.ToUpper(s)
+		case "BLOOMFILTER":
+			col.BloomFilterType = strings.ToUpper(s)
+		case "IN_MEMORY":
+			col.InMemory, err = strconv.ParseBool(s)
+		case "BLOCKCACHE":
+			col.BlockCacheEnabled, err = strconv.ParseBool(s)
+		default:
+			return nil, fmt.Errorf("unknown family option %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return col, nil
+}
+
+func parseInt32(s string) (int32, error) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	return int32(n), err
+}
+
+func (sh *shell) disable(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err = sh.client.DisableTable(table); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "Disabled table %s in %s\n", table, elapsed(start))
+	return nil
+}
+
+func (sh *shell) enable(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err = sh.client.EnableTable(table); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "Enabled table %s in %s\n", table, elapsed(start))
+	return nil
+}
+
+func (sh *shell) drop(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := sh.client.IsTableEnabled(table)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return fmt.Errorf("table %s is enabled, disable it first", table)
+	}
+
+	start := time.Now()
+	if err = sh.client.DeleteTable(table); err != nil {
+		return err
+	}
+	sh.tables = nil
+	delete(sh.families, table)
+	fmt.Fprintf(sh.out, "Dropped table %s in %s\n", table, elapsed(start))
+	return nil
+}
+
+func (sh *shell) get(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	row, err := a.str(1)
+	if err != nil {
+		return err
+	}
+
+	opts := a.opts()
+	columns, err := a.strs(2)
+	if err != nil {
+		return err
+	}
+	if v, ok := opts["COLUMNS"]; ok {
+		columns = append(columns, toStrings(v)...)
+	}
+	if v, ok := opts["COLUMN"]; ok {
+		columns = append(columns, toStrings(v)...)
+	}
+	ts, err := optInt(opts, "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	versions, err := optInt(opts, "VERSIONS")
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var r *goh.Row
+	switch {
+	case versions > 1:
+		if len(columns) == 0 {
+			return errors.New("VERSIONS needs COLUMNS")
+		}
+		r = &goh.Row{Key: []byte(row)}
+		for _, col := range columns {
+			var cells []*goh.Cell
+			if ts > 0 {
+				cells, err = sh.client.ReadVersionsTs(table, []byte(row), col, ts, int32(versions), nil)
+			} else {
+				cells, err = sh.client.ReadVersions(table, []byte(row), col, int32(versions), nil)
+			}
+			if err != nil {
+				return err
+			}
+			r.Cells = append(r.Cells, cells...)
+		}
+	case len(columns) > 0 && ts > 0:
+		r, err = sh.client.ReadRowWithColumnsTs(table, []byte(row), columns, ts, nil)
+	case len(columns) > 0:
+		r, err = sh.client.ReadRowWithColumns(table, []byte(row), columns, nil)
+	case ts > 0:
+		r, err = sh.client.ReadRowTs(table, []byte(row), ts, nil)
+	default:
+		r, err = sh.client.ReadRow(table, []byte(row), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLUMN\tCELL")
+	n := 0
+	if r != nil {
+		for _, c := range r.Cells {
+			fmt.Fprintf(w, " %s\ttimestamp=%d, value=%s\n", sh.value([]byte(c.Column())), c.Timestamp, sh.value(c.Value))
+			n++
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(sh.out, "%d cell(s) in %s\n", n, elapsed(start))
+	return nil
+}
+
+func (sh *shell) put(a *cmdArgs) error {
+	if len(a.pos) < 4 {
+		return a.usageError()
+	}
+	table, _ := a.str(0)
+	row, _ := a.str(1)
+	column, _ := a.str(2)
+	value, err := a.str(3)
+	if err != nil {
+		return err
+	}
+	m := goh.NewRowMutation([]byte(row))
+	if len(a.pos) > 4 {
+		ts, err := a.int(4)
+		if err != nil {
+			return err
+		}
+		m.Timestamp(ts)
+	}
+	m.Put(column, []byte(value))
+
+	start := time.Now()
+	if err = sh.client.ApplyRowMutation(table, m, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "0 row(s) in %s\n", elapsed(start))
+	return nil
+}
+
+func (sh *shell) delete(a *cmdArgs) error {
+	if len(a.pos) < 3 {
+		return a.usageError()
+	}
+	table, _ := a.str(0)
+	row, _ := a.str(1)
+	column, err := a.str(2)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if len(a.pos) > 3 {
+		ts, e := a.int(3)
+		if e != nil {
+			return e
+		}
+		err = sh.client.DeleteAllTs(table, []byte(row), column, ts, nil)
+	} else {
+		err = sh.client.DeleteAll(table, []byte(row), column, nil)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "0 row(s) in %s\n", elapsed(start))
+	return nil
+}
+
+func (sh *shell) deleteAll(a *cmdArgs) error {
+	if len(a.pos) < 2 {
+		return a.usageError()
+	}
+	table, _ := a.str(0)
+	row, err := a.str(1)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if len(a.pos) > 2 {
+		column, e := a.str(2)
+		if e != nil {
+			return e
+		}
+		err = sh.client.DeleteAll(table, []byte(row), column, nil)
+	} else {
+		err = sh.client.DeleteAllRow(table, []byte(row), nil)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "0 row(s) in %s\n", elapsed(start))
+	return nil
+}
+
+/*
+scanOptions builds the scan of the scan and count commands
+*/
+func scanOptions(opts map[string]interface{}) (*goh.TScan, int64, error) {
+	scan := &goh.TScan{Caching: 100}
+	var limit int64
+	for key, v := range opts {
+		var err error
+		switch key {
+		case "STARTROW":
+			scan.StartRow = []byte(toString(v))
+		case "STOPROW", "ENDROW":
+			scan.StopRow = []byte(toString(v))
+		case "ROWPREFIXFILTER":
+			p := []byte(toString(v))
+			scan.StartRow = p
+			scan.SetFilter(filter.Prefix(p))
+		case "COLUMNS", "COLUMN":
+			scan.Columns = append(scan.Columns, toStrings(v)...)
+		case "LIMIT":
+			limit, err = strconv.ParseInt(toString(v), 10, 64)
+		case "TIMESTAMP":
+			scan.Timestamp, err = strconv.ParseInt(toString(v), 10, 64)
+		case "CACHE":
+			scan.Caching, err = parseInt32(toString(v))
+		case "FILTER":
+			var f filter.Filter
+			if f, err = filter.Parse(toString(v)); err == nil {
+				scan.SetFilter(f)
+			}
+		case "INTERVAL":
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	if limit > 0 && int64(scan.Caching) > limit {
+		scan.Caching = int32(limit)
+	}
+	return scan, limit, nil
+}
+
+func (sh *shell) scan(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	scan, limit, err := scanOptions(a.opts())
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	w := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROW\tCOLUMN+CELL")
+	n := int64(0)
+	err = sh.client.Table(table).Scan(scan, nil, func(r *goh.Row) error {
+		for _, c := range r.Cells {
+			fmt.Fprintf(w, " %s\tcolumn=%s, timestamp=%d, value=%s\n",
+				sh.value(r.Key), sh.value([]byte(c.Column())), c.Timestamp, sh.value(c.Value))
+		}
+		n++
+		if n%100 == 0 {
+			w.Flush()
+		}
+		if limit > 0 && n >= limit {
+			return errStop
+		}
+		return nil
+	})
+	w.Flush()
+	if err != nil && err != errStop {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%d row(s) in %s\n", n, elapsed(start))
+	return nil
+}
+
+/*
+errStop ends a scan early
+*/
+var errStop = errors.New("stop")
+
+func (sh *shell) count(a *cmdArgs) error {
+	table, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	opts := a.opts()
+	scan, _, err := scanOptions(opts)
+	if err != nil {
+		return err
+	}
+	if _, ok := opts["CACHE"]; !ok {
+		scan.Caching = 1000
+	}
+	if scan.FilterString == "" {
+		scan.SetFilter(filter.And(filter.FirstKeyOnly(), filter.KeyOnly()))
+	}
+	interval, err := optInt(opts, "INTERVAL")
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = 1000
+	}
+
+	start := time.Now()
+	n := int64(0)
+	err = sh.client.Table(table).Scan(scan, nil, func(r *goh.Row) error {
+		n++
+		if n%interval == 0 {
+			fmt.Fprintf(sh.out, "Current count: %d, row: %s\n", n, sh.value(r.Key))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%d row(s) in %s\n", n, elapsed(start))
+	return nil
+}
+
+func (sh *shell) incr(a *cmdArgs) error {
+	if len(a.pos) < 3 {
+		return a.usageError()
+	}
+	table, _ := a.str(0)
+	row, _ := a.str(1)
+	column, err := a.str(2)
+	if err != nil {
+		return err
+	}
+	amount := int64(1)
+	if len(a.pos) > 3 {
+		if amount, err = a.int(3); err != nil {
+			return err
+		}
+	}
+
+	v, err := sh.client.AtomicIncrement(table, []byte(row), column, amount)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "COUNTER VALUE = %d\n", v)
+	return nil
+}
+
+func (sh *shell) setDecode(a *cmdArgs) error {
+	if len(a.pos) == 0 {
+		fmt.Fprintf(sh.out, "decode %s, one of %s\n", sh.decode, decoderNames())
+		return nil
+	}
+	name, err := a.str(0)
+	if err != nil {
+		return err
+	}
+	if _, ok := decoders[name]; !ok {
+		return fmt.Errorf("unknown decoding %q, one of %s", name, decoderNames())
+	}
+	sh.decode = name
+	return nil
+}
+
+func (sh *shell) help(a *cmdArgs) error {
+	name := ""
+	if len(a.pos) > 0 {
+		name, _ = a.str(0)
+	}
+	for _, c := range shellCommands {
+		if name == "" || c.name == name {
+			fmt.Fprintln(sh.out, " ", c.usage)
+		}
+	}
+	if name == "" {
+		fmt.Fprintln(sh.out, "  exit")
+		fmt.Fprintln(sh.out, "\nStrings are quoted with ' or \", double quoted strings take \\xNN escapes.")
+	}
+	return nil
+}
+
+/*
+completion
+*/
+
+var optionKeys = []string{"BLOCKCACHE", "BLOOMFILTER", "CACHE", "COLUMNS", "COMPRESSION", "FILTER", "INTERVAL", "IN_MEMORY",
+	"LIMIT", "NAME", "ROWPREFIXFILTER", "STARTROW", "STOPROW", "TIMESTAMP", "TTL", "VERSIONS"}
+
+func (sh *shell) tableNames() []string {
+	if sh.tables == nil {
+		sh.tables, _ = sh.client.GetTableNames()
+	}
+	return sh.tables
+}
+
+func (sh *shell) familyNames(table string) []string {
+	if fams, ok := sh.families[table]; ok {
+		return fams
+	}
+	cols, err := sh.client.GetColumnDescriptors(table)
+	if err != nil {
+		return nil
+	}
+	var fams []string
+	for name := range cols {
+		fams = append(fams, strings.TrimSuffix(name, ":")+":")
+	}
+	sort.Strings(fams)
+	if sh.families == nil {
+		sh.families = make(map[string][]string)
+	}
+	sh.families[table] = fams
+	return fams
+}
+
+/*
+complete completes command names, then table names, then families of
+the table, and option keys inside braces
+*/
+func (sh *shell) complete(before string) (string, []string) {
+	i := strings.LastIndexAny(before, " \t,'\"[{>")
+	word := before[i+1:]
+	head := before[:i+1]
+
+	var words []string
+	fields := strings.FieldsFunc(head, func(r rune) bool { return strings.ContainsRune(" \t,'\"", r) })
+	switch {
+	case len(fields) == 0:
+		for _, c := range shellCommands {
+			words = append(words, c.name)
+		}
+		words = append(words, "exit")
+	case strings.Count(head, "{") > strings.Count(head, "}") && !strings.HasSuffix(strings.TrimRight(head, " \t'\""), ">"):
+		words = optionKeys
+	case len(fields) == 1:
+		words = sh.tableNames()
+	default:
+		words = sh.familyNames(fields[1])
+	}
+
+	var candidates []string
+	for _, w := range words {
+		if strings.HasPrefix(w, word) {
+			candidates = append(candidates, w)
+		}
+	}
+	return word, candidates
+}
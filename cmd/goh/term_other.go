@@ -0,0 +1,21 @@
+//go:build !linux
+
+/*
+
+
+ */
+
+package main
+
+import "errors"
+
+/*
+isTerminal reports false, the line editor falls back to plain line reads
+*/
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is only supported on linux")
+}
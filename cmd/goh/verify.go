@@ -0,0 +1,120 @@
+/*
+
+
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/chenjingping/goh"
+)
+
+func init() {
+	register(&command{name: "verify", usage: "compare a table with its copy on another cluster", run: runVerify})
+}
+
+func runVerify(client *goh.HClient, args []string) error {
+	var table, to, toTable, start, stop, columns string
+	var conf goh.VerifyConfig
+
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&table, "table", "", "source table")
+	fs.StringVar(&to, "to", "", "gateway of the target, host[:port], the source gateway by default")
+	fs.StringVar(&toTable, "to-table", "", "target table, the source name by default")
+	fs.StringVar(&start, "start", "", "first row, \\xNN escapes allowed")
+	fs.StringVar(&stop, "stop", "", "row to stop before")
+	fs.StringVar(&columns, "columns", "", "comma separated families or family:qualifier columns")
+	fs.BoolVar(&conf.Timestamps, "timestamps", false, "compare the cell timestamps too")
+	fs.IntVar(&conf.ChunkRows, "chunk", 1000, "rows hashed together")
+	fs.IntVar(&conf.Parallel, "parallel", 4, "regions verified at once")
+	fs.IntVar(&conf.MaxDiffs, "max-diffs", 100, "stop after this many differing rows, 0 for no limit")
+	fs.BoolVar(&conf.Repair, "repair", false, "make the target rows match the source")
+	fs.Parse(args)
+
+	if table == "" {
+		return errors.New("-table is required")
+	}
+	if toTable == "" {
+		toTable = table
+	}
+	if to == "" && toTable == table {
+		return errors.New("-to or -to-table is required")
+	}
+
+	var err error
+	if conf.Range.Start, err = parseBinary(start); err != nil {
+		return fmt.Errorf("-start: %v", err)
+	}
+	if conf.Range.Stop, err = parseBinary(stop); err != nil {
+		return fmt.Errorf("-stop: %v", err)
+	}
+	conf.Columns = splitList(columns)
+	conf.Report = func(d *goh.RowDiff) {
+		fmt.Printf("%-15s %s\n", d.Kind, toStringBinary(d.Key))
+		if d.Kind != goh.Different {
+			return
+		}
+		for _, col := range diffColumns(d.Source, d.Target, conf.Timestamps) {
+			fmt.Printf("%15s %s\n", "", col)
+		}
+	}
+
+	src := goh.NewPool(conf.Parallel, func() (*goh.HClient, error) { return conn.dial("") })
+	defer src.Close()
+	dst := goh.NewPool(conf.Parallel, func() (*goh.HClient, error) { return conn.dial(to) })
+	defer dst.Close()
+
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stopSignals()
+
+	res, err := goh.Verify(ctx, src, dst, table, toTable, conf)
+	if res != nil {
+		fmt.Fprintf(os.Stderr, "%d chunks, %d mismatched, %d source rows, %d target rows, %d differing rows",
+			res.Chunks, res.Mismatched, res.SourceRows, res.TargetRows, res.Diffs)
+		if res.Truncated {
+			fmt.Fprint(os.Stderr, " (stopped at -max-diffs)")
+		}
+		if conf.Repair {
+			fmt.Fprintf(os.Stderr, ", %d repaired", res.Repaired)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	if res.Mismatched > 0 && !conf.Repair {
+		return errors.New("tables differ")
+	}
+	return nil
+}
+
+/*
+diffColumns return the columns whose latest cells differ
+*/
+func diffColumns(a, b *goh.Row, timestamps bool) []string {
+	seen := make(map[string]bool)
+	var cols []string
+	for _, row := range []*goh.Row{a, b} {
+		for _, col := range row.Columns() {
+			if seen[col] {
+				continue
+			}
+			seen[col] = true
+
+			family, qualifier := goh.SplitColumn(col)
+			x, y := a.Cell(family, qualifier), b.Cell(family, qualifier)
+			if x == nil || y == nil || string(x.Value) != string(y.Value) ||
+				(timestamps && x.Timestamp != y.Timestamp) {
+				cols = append(cols, col)
+			}
+		}
+	}
+	return cols
+}
@@ -0,0 +1,259 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+A struct maps to a row through hbase tags:
+
+	type User struct {
+		ID    string  `hbase:"rowkey"`
+		Name  string  `hbase:"info:name"`
+		Age   int     `hbase:"info:age"`
+		Email *string `hbase:"info:email"`
+	}
+
+Values are stored as text, the way PutTyped writes them and
+ScanHbaseColumns reads them. A nil pointer field deletes its column on
+put and stays nil when the column is absent.
+*/
+
+type fieldCodec struct {
+	index  []int
+	column string
+	ptr    bool
+	kind   reflect.Kind
+}
+
+/*
+StructCodec maps the tagged fields of a struct type to columns
+*/
+type StructCodec struct {
+	typ     reflect.Type
+	key     []int // index of the rowkey field, nil when there is none
+	fields  []fieldCodec
+	columns []string
+}
+
+var codecs sync.Map // reflect.Type -> *StructCodec
+
+/*
+CodecOf return the codec of a struct type, or of the struct a pointer
+points to
+*/
+func CodecOf(t reflect.Type) (*StructCodec, error) {
+	if t == nil {
+		return nil, fmt.Errorf("goh: can't map type %v to a row", t)
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if c, ok := codecs.Load(t); ok {
+		return c.(*StructCodec), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("goh: can't map type %s to a row", t)
+	}
+
+	c := &StructCodec{typ: t}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("hbase")
+		if !ok || tag == "-" || f.PkgPath != "" {
+			continue
+		}
+
+		ft, ptr := f.Type, false
+		if ft.Kind() == reflect.Ptr {
+			ft, ptr = ft.Elem(), true
+		}
+		if !codecKind(ft) {
+			return nil, fmt.Errorf("goh: field %s.%s of type %s can't be mapped", t.Name(), f.Name, f.Type)
+		}
+
+		if tag == "rowkey" {
+			if c.key != nil || ptr {
+				return nil, fmt.Errorf("goh: field %s.%s can't be the row key", t.Name(), f.Name)
+			}
+			c.key = f.Index
+			continue
+		}
+		if strings.IndexByte(tag, ':') <= 0 {
+			return nil, fmt.Errorf("goh: tag %q of %s.%s is not family:qualifier", tag, t.Name(), f.Name)
+		}
+		c.fields = append(c.fields, fieldCodec{index: f.Index, column: tag, ptr: ptr, kind: ft.Kind()})
+		c.columns = append(c.columns, tag)
+	}
+
+	if len(c.fields) == 0 {
+		return nil, fmt.Errorf("goh: type %s has no hbase tagged field", t)
+	}
+	v, _ := codecs.LoadOrStore(t, c)
+	return v.(*StructCodec), nil
+}
+
+func codecKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+/*
+Columns return the mapped family:qualifier columns
+*/
+func (c *StructCodec) Columns() []string {
+	return c.columns
+}
+
+/*
+Key return the rowkey field of v as bytes, nil when the type has none
+*/
+func (c *StructCodec) Key(v interface{}) []byte {
+	if c.key == nil {
+		return nil
+	}
+	return encodeValue(reflect.Indirect(reflect.ValueOf(v)).FieldByIndex(c.key))
+}
+
+/*
+Mutation return the puts, and deletes for nil pointers, of the mapped
+fields of v
+*/
+func (c *StructCodec) Mutation(row []byte, v interface{}) *RowMutation {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	m := NewRowMutation(row)
+	for _, f := range c.fields {
+		fv := rv.FieldByIndex(f.index)
+		if f.ptr {
+			if fv.IsNil() {
+				m.DeleteColumn(f.column)
+				continue
+			}
+			fv = fv.Elem()
+		}
+		m.Put(f.column, encodeValue(fv))
+	}
+	return m
+}
+
+/*
+Mutations return the hbase1 mutations of v for MutateRow
+*/
+func (c *StructCodec) Mutations(v interface{}) []*hbase1.Mutation {
+	groups, _ := c.Mutation(nil, v).Mutations()
+	if len(groups) == 0 {
+		return nil
+	}
+	return groups[0].Mutations
+}
+
+/*
+Decode sets the fields of the struct ptr points to from row, fields of
+absent columns are left untouched
+*/
+func (c *StructCodec) Decode(row *Row, ptr interface{}) error {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Type() != c.typ {
+		return fmt.Errorf("goh: can't decode into %T, need *%s", ptr, c.typ)
+	}
+	rv = rv.Elem()
+
+	if c.key != nil {
+		if err := decodeValue(rv.FieldByIndex(c.key), row.Key); err != nil {
+			return fmt.Errorf("goh: row key %q: %v", row.Key, err)
+		}
+	}
+
+	for _, f := range c.fields {
+		family, qualifier := SplitColumn(f.column)
+		cell := row.Cell(family, qualifier)
+		if cell == nil {
+			continue
+		}
+
+		fv := rv.FieldByIndex(f.index)
+		if f.ptr {
+			p := reflect.New(fv.Type().Elem())
+			fv.Set(p)
+			fv = p.Elem()
+		}
+		if err := decodeValue(fv, cell.Value); err != nil {
+			return fmt.Errorf("goh: column %s: %v", f.column, err)
+		}
+	}
+	return nil
+}
+
+func encodeValue(v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String())
+	case reflect.Slice:
+		return v.Bytes()
+	case reflect.Bool:
+		return strconv.AppendBool(nil, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(nil, v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(nil, v.Uint(), 10)
+	case reflect.Float32:
+		return strconv.AppendFloat(nil, v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.AppendFloat(nil, v.Float(), 'g', -1, 64)
+	}
+	panic("goh: unmapped kind " + v.Kind().String())
+}
+
+func decodeValue(v reflect.Value, b []byte) error {
+	s := string(b)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Slice:
+		v.SetBytes(append([]byte(nil), b...))
+	case reflect.Bool:
+		x, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(x)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+CopyConfig selects the cells Copy reads and tells how it writes them
+*/
+type CopyConfig struct {
+	Range     KeyRange
+	Columns   []string // families or family:qualifier columns, all when empty
+	StartTime int64    // cells older than this are left out
+	EndTime   int64    // cells at this time or newer are left out, 0 for none
+
+	// ColumnMap renames source columns, FamilyMap the families of the
+	// columns ColumnMap does not name. An empty name leaves them out.
+	ColumnMap map[string]string
+	FamilyMap map[string]string
+
+	ServerTimestamps bool    // write at the destination server time instead of the source timestamps
+	Parallel         int     // ranges copied at once, 4 by default
+	BatchRows        int     // rows per write and checkpoint, 1000 by default
+	RowsPerSec       float64 // throttle over all ranges, 0 for no limit
+
+	// Checkpoint is a file recording the copied ranges; a copy started
+	// with the file of an interrupted one resumes where it stopped
+	Checkpoint string
+
+	Progress         func(p CopyProgress) // called every ProgressInterval and at the end
+	ProgressInterval time.Duration        // 10s by default
+}
+
+/*
+CopyProgress tells how far a copy went
+*/
+type CopyProgress struct {
+	Ranges     int   // regions of the source overlapping the key range
+	RangesDone int   // ranges copied to the end
+	Rows       int64 // rows written by this run
+	Cells      int64 // cells written by this run
+	Elapsed    time.Duration
+}
+
+/*
+copyRange is a region of the source table clipped to the key range
+*/
+type copyRange struct {
+	Start []byte `json:"start"`
+	Stop  []byte `json:"stop"`
+	Last  []byte `json:"last,omitempty"` // last row written
+	Done  bool   `json:"done"`
+}
+
+type copyCheckpoint struct {
+	Table  string       `json:"table"`
+	Start  []byte       `json:"start"`
+	Stop   []byte       `json:"stop"`
+	Ranges []*copyRange `json:"ranges"`
+}
+
+type copier struct {
+	src      *Pool
+	dst      *Pool
+	srcTable string
+	dstTable string
+	conf     CopyConfig
+	limit    *TokenBucket
+	started  time.Time
+
+	mu sync.Mutex
+	cp *copyCheckpoint
+
+	rows  int64
+	cells int64
+}
+
+/*
+Copy copies a table from the clients of src to the clients of dst. The
+source regions overlapping the key range are scanned in parallel, each
+with its own source and destination client. Copying again with a
+StartTime brings over the cells written since, deletes are not copied.
+*/
+func Copy(ctx context.Context, src, dst *Pool, srcTable, dstTable string, conf CopyConfig) (CopyProgress, error) {
+	if conf.Parallel <= 0 {
+		conf.Parallel = 4
+	}
+	if conf.BatchRows <= 0 {
+		conf.BatchRows = 1000
+	}
+	if conf.ProgressInterval <= 0 {
+		conf.ProgressInterval = 10 * time.Second
+	}
+
+	c := &copier{
+		src:      src,
+		dst:      dst,
+		srcTable: srcTable,
+		dstTable: dstTable,
+		conf:     conf,
+		started:  time.Now(),
+	}
+	if conf.RowsPerSec > 0 {
+		c.limit = NewTokenBucket(conf.RowsPerSec, int(conf.RowsPerSec)+1)
+	}
+
+	if err := c.loadCheckpoint(); err != nil {
+		return CopyProgress{}, err
+	}
+	if c.cp == nil {
+		if err := c.planRanges(); err != nil {
+			return CopyProgress{}, err
+		}
+	}
+	if err := c.saveCheckpoint(); err != nil {
+		return c.progress(), err
+	}
+
+	var todo []*copyRange
+	for _, r := range c.cp.Ranges {
+		if !r.Done {
+			todo = append(todo, r)
+		}
+	}
+
+	if conf.Progress != nil {
+		stop := everyInterval(conf.ProgressInterval, func() { conf.Progress(c.progress()) })
+		defer func() {
+			stop()
+			conf.Progress(c.progress())
+		}()
+	}
+	err := forEachRange(ctx, todo, conf.Parallel, c.copyRange)
+	return c.progress(), err
+}
+
+func (c *copier) progress() CopyProgress {
+	p := CopyProgress{
+		Rows:    atomic.LoadInt64(&c.rows),
+		Cells:   atomic.LoadInt64(&c.cells),
+		Elapsed: time.Since(c.started),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cp != nil {
+		p.Ranges = len(c.cp.Ranges)
+		for _, r := range c.cp.Ranges {
+			if r.Done {
+				p.RangesDone++
+			}
+		}
+	}
+	return p
+}
+
+/*
+planRanges clips the source regions to the key range
+*/
+func (c *copier) planRanges() (err error) {
+	client, err := c.src.Get()
+	if err != nil {
+		return err
+	}
+	defer func() { c.src.Put(client, err) }()
+
+	ranges, err := regionRanges(client, c.srcTable, c.conf.Range)
+	if err != nil {
+		return err
+	}
+
+	kr := c.conf.Range
+	c.cp = &copyCheckpoint{Table: c.srcTable, Start: kr.Start, Stop: kr.Stop}
+	for _, r := range ranges {
+		c.cp.Ranges = append(c.cp.Ranges, &copyRange{Start: r.Start, Stop: r.Stop})
+	}
+	return nil
+}
+
+func (c *copier) loadCheckpoint() error {
+	if c.conf.Checkpoint == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.conf.Checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cp := &copyCheckpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return fmt.Errorf("goh: checkpoint %s: %v", c.conf.Checkpoint, err)
+	}
+	kr := c.conf.Range
+	if cp.Table != c.srcTable || !bytes.Equal(cp.Start, kr.Start) || !bytes.Equal(cp.Stop, kr.Stop) {
+		return fmt.Errorf("goh: checkpoint %s is for another table or key range", c.conf.Checkpoint)
+	}
+	c.cp = cp
+	return nil
+}
+
+/*
+saveCheckpoint writes the checkpoint, c.mu held or no copy running
+*/
+func (c *copier) saveCheckpoint() error {
+	if c.conf.Checkpoint == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.cp)
+	if err != nil {
+		return err
+	}
+	tmp := c.conf.Checkpoint + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.conf.Checkpoint)
+}
+
+/*
+mark records that the range is written up to last
+*/
+func (c *copier) mark(r *copyRange, last []byte, done bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last != nil {
+		r.Last = last
+	}
+	r.Done = done
+	return c.saveCheckpoint()
+}
+
+func (c *copier) copyRange(ctx context.Context, r *copyRange) (err error) {
+	from, err := c.src.Get()
+	if err != nil {
+		return err
+	}
+	defer func() { c.src.Put(from, err) }()
+	to, err := c.dst.Get()
+	if err != nil {
+		return err
+	}
+	defer func() { c.dst.Put(to, err) }()
+
+	scan := &TScan{
+		StartRow:  r.Start,
+		StopRow:   r.Stop,
+		Columns:   c.conf.Columns,
+		Timestamp: c.conf.EndTime,
+		Caching:   100,
+	}
+	c.mu.Lock()
+	if r.Last != nil {
+		scan.StartRow = append(append([]byte{}, r.Last...), 0)
+	}
+	c.mu.Unlock()
+
+	// the mutator flushes on its own only past MaxBytes, which is
+	// written again after a resume
+	bm := NewBufferedMutator(to, c.dstTable, MutatorConfig{MaxRows: c.conf.BatchRows + 1})
+	var last []byte
+	pending := 0
+	err = scanAll(from, c.srcTable, scan, nil, func(tr *hbase1.TRowResult_) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		row := ToRow(tr)
+		if m, cells := c.mutation(row); cells > 0 {
+			if c.limit != nil {
+				c.limit.Wait()
+			}
+			if err := bm.Mutate(m); err != nil {
+				return err
+			}
+			atomic.AddInt64(&c.rows, 1)
+			atomic.AddInt64(&c.cells, int64(cells))
+		}
+
+		last = row.Key
+		if pending++; pending < c.conf.BatchRows {
+			return nil
+		}
+		pending = 0
+		if err := bm.Flush(); err != nil {
+			return err
+		}
+		return c.mark(r, last, false)
+	})
+	if e := bm.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return c.mark(r, last, true)
+}
+
+/*
+mutation puts the cells of the row under their destination columns
+*/
+func (c *copier) mutation(row *Row) (*RowMutation, int) {
+	m := NewRowMutation(row.Key)
+	n := 0
+	for _, cell := range row.Cells {
+		if cell.Timestamp < c.conf.StartTime {
+			continue
+		}
+		column, ok := c.conf.mapColumn(cell)
+		if !ok {
+			continue
+		}
+		if !c.conf.ServerTimestamps {
+			m.Timestamp(cell.Timestamp)
+		}
+		m.Put(column, cell.Value)
+		n++
+	}
+	return m, n
+}
+
+func (conf *CopyConfig) mapColumn(cell *Cell) (string, bool) {
+	column := cell.Column()
+	if to, ok := conf.ColumnMap[column]; ok {
+		return to, to != ""
+	}
+	if to, ok := conf.FamilyMap[cell.Family]; ok {
+		return to + ":" + cell.Qualifier, to != ""
+	}
+	return column, true
+}
@@ -0,0 +1,178 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/filter"
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+DeleteConfig selects the rows BulkDelete removes
+*/
+type DeleteConfig struct {
+	Range  KeyRange
+	Prefix []byte        // rows starting with Prefix, within Range
+	Filter filter.Filter // server side filter, nil for all rows
+
+	// Columns are the families or family:qualifier columns deleted, the
+	// whole rows when empty
+	Columns []string
+
+	RowByRow   bool    // delete whole rows with DeleteAllRow instead of batched family deletes
+	BatchRows  int     // rows per MutateRows call, 1000 by default
+	RowsPerSec float64 // throttle over all regions, 0 for no limit
+	Parallel   int     // regions scanned at once, 4 by default
+	DryRun     bool    // count the rows, delete nothing
+
+	Progress         func(p ScanProgress) // called every ProgressInterval and at the end
+	ProgressInterval time.Duration        // 10s by default
+}
+
+/*
+prefixRange narrows kr to the keys starting with prefix
+*/
+func prefixRange(kr KeyRange, prefix []byte) KeyRange {
+	if len(prefix) == 0 {
+		return kr
+	}
+	if bytes.Compare(prefix, kr.Start) > 0 {
+		kr.Start = prefix
+	}
+
+	// the stop is the prefix with its last byte below 0xff incremented
+	stop := append([]byte{}, prefix...)
+	for len(stop) > 0 && stop[len(stop)-1] == 0xff {
+		stop = stop[:len(stop)-1]
+	}
+	if len(stop) > 0 {
+		stop[len(stop)-1]++
+		if len(kr.Stop) == 0 || bytes.Compare(stop, kr.Stop) < 0 {
+			kr.Stop = stop
+		}
+	}
+	return kr
+}
+
+/*
+BulkDelete deletes the selected rows, or the selected columns of them,
+with key-only parallel region scans and return the number of rows
+deleted, or found on a dry run. Whole rows are deleted by deleting every
+family of the table, batched through MutateRows.
+*/
+func BulkDelete(ctx context.Context, pool *Pool, tableName string, conf DeleteConfig) (int64, error) {
+	if conf.BatchRows <= 0 {
+		conf.BatchRows = 1000
+	}
+	kr := prefixRange(conf.Range, conf.Prefix)
+	ps := newParallelScan(pool, tableName, kr, conf.Parallel, conf.Progress, conf.ProgressInterval)
+
+	scan := TScan{Caching: 1000}
+	if conf.Filter != nil {
+		// FirstKeyOnly would hide the columns a value filter tests
+		scan.SetFilter(filter.And(conf.Filter, filter.KeyOnly()))
+	} else {
+		scan.SetFilter(filter.And(filter.FirstKeyOnly(), filter.KeyOnly()))
+	}
+
+	var deletes []*hbase1.Mutation
+	if !conf.DryRun && !(conf.RowByRow && len(conf.Columns) == 0) {
+		var err error
+		if deletes, err = deleteMutations(pool, tableName, conf.Columns); err != nil {
+			return 0, err
+		}
+	}
+
+	var limit *TokenBucket
+	if conf.RowsPerSec > 0 {
+		limit = NewTokenBucket(conf.RowsPerSec, int(conf.RowsPerSec)+1)
+	}
+
+	var deleted int64
+	err := ps.run(ctx, scan, func(client *HClient) (func(*hbase1.TRowResult_) error, func() error) {
+		var batch []*hbase1.BatchMutation
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := client.MutateRows(tableName, batch, nil); err != nil {
+				return err
+			}
+			atomic.AddInt64(&deleted, int64(len(batch)))
+			batch = batch[:0]
+			return nil
+		}
+
+		add := func(r *hbase1.TRowResult_) error {
+			if conf.DryRun {
+				atomic.AddInt64(&deleted, 1)
+				return nil
+			}
+			if limit != nil {
+				limit.Wait()
+			}
+			if deletes == nil {
+				if err := client.DeleteAllRow(tableName, r.Row, nil); err != nil {
+					return err
+				}
+				atomic.AddInt64(&deleted, 1)
+				return nil
+			}
+			batch = append(batch, NewBatchMutation(r.Row, deletes))
+			if len(batch) >= conf.BatchRows {
+				return flush()
+			}
+			return nil
+		}
+		return add, flush
+	})
+	return atomic.LoadInt64(&deleted), err
+}
+
+/*
+deleteMutations return the delete mutations of the columns, of every
+family of the table when columns is empty
+*/
+func deleteMutations(pool *Pool, tableName string, columns []string) ([]*hbase1.Mutation, error) {
+	if len(columns) == 0 {
+		client, err := pool.Get()
+		if err != nil {
+			return nil, err
+		}
+		families, err := client.GetColumnDescriptors(tableName)
+		pool.Put(client, err)
+		if err != nil {
+			return nil, err
+		}
+		for name := range families {
+			columns = append(columns, strings.TrimSuffix(name, ":"))
+		}
+		if len(columns) == 0 {
+			return nil, errors.New("goh: table " + tableName + " has no families")
+		}
+	}
+
+	m := NewRowMutation(nil)
+	for _, column := range columns {
+		if strings.IndexByte(column, ':') >= 0 {
+			m.DeleteColumn(column)
+		} else {
+			m.DeleteFamily(column)
+		}
+	}
+	groups, err := m.Mutations()
+	if err != nil {
+		return nil, err
+	}
+	return groups[0].Mutations, nil
+}
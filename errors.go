@@ -7,10 +7,33 @@ package goh
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 
 	"github.com/chenjingping/goh/hbase1"
 )
 
+/*
+errors returned by the client before a call is sent
+*/
+var (
+	ErrCircuitOpen          = errors.New("goh: circuit breaker is open")      // the endpoint breaker rejected the call
+	ErrRateLimited          = errors.New("goh: rate limit exceeded")          // the table rate limit rejected the call
+	ErrUnknownFamily        = errors.New("goh: column family does not exist") // the mutation names a family the table lacks
+	ErrReadOnly             = errors.New("goh: client is read-only")          // the guard rejects every mutation and admin call
+	ErrProtectedTable       = errors.New("goh: table is protected")           // the guard allow or deny list rejected the table
+	ErrConfirmationRequired = errors.New("goh: confirmation token required")  // DeleteTable of a production table
+	ErrNilValue             = errors.New("goh: nil value")                    // a typed write got a nil pointer
+)
+
+/*
+errors standing for a server answer
+*/
+var (
+	ErrNotFound    = errors.New("goh: row not found")        // a typed read found no row
+	ErrTableExists = errors.New("goh: table already exists") // CreateTable found the table
+)
+
 /*
 HbaseError
 */
@@ -65,6 +88,14 @@ func (e *HbaseError) Error() string {
 	return e.String()
 }
 
+/*
+Unwrap return the underlying error, so errors.Is finds ErrCircuitOpen,
+ErrRateLimited and the guard errors behind an HbaseError
+*/
+func (e *HbaseError) Unwrap() error {
+	return e.Err
+}
+
 func checkHbaseError(io *hbase1.IOError, err error) error {
 	if io != nil || err != nil {
 		return newHbaseError(io, nil, err)
@@ -78,3 +109,19 @@ func checkHbaseArgError(io *hbase1.IOError, arg *hbase1.IllegalArgument, err err
 	}
 	return nil
 }
+
+/*
+isTableExists reports whether a CreateTable error says the table exists,
+as AlreadyExists or as an IOError wrapping TableExistsException
+*/
+func isTableExists(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var exists *hbase1.AlreadyExists
+	if errors.As(err, &exists) {
+		return true
+	}
+	return strings.Contains(err.Error(), "TableExistsException")
+}
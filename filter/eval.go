@@ -0,0 +1,433 @@
+/*
+
+
+ */
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+Cell is a cell seen by the local evaluator
+*/
+type Cell struct {
+	Family    []byte
+	Qualifier []byte
+	Value     []byte
+	Timestamp int64
+}
+
+/*
+Record is a row seen by the local evaluator, cells sorted by family and
+qualifier
+*/
+type Record struct {
+	Key   []byte
+	Cells []Cell
+}
+
+/*
+FromTRowResult converts a thrift1 row result
+*/
+func FromTRowResult(r *hbase1.TRowResult_) Record {
+	row := Record{Key: r.Row}
+	for name, cell := range r.Columns {
+		family, qualifier := name, ""
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			family, qualifier = name[:i], name[i+1:]
+		}
+		row.Cells = append(row.Cells, Cell{
+			Family:    []byte(family),
+			Qualifier: []byte(qualifier),
+			Value:     cell.Value,
+			Timestamp: cell.Timestamp,
+		})
+	}
+
+	sort.Slice(row.Cells, func(i, j int) bool {
+		a, b := row.Cells[i], row.Cells[j]
+		if c := bytes.Compare(a.Family, b.Family); c != 0 {
+			return c < 0
+		}
+		if c := bytes.Compare(a.Qualifier, b.Qualifier); c != 0 {
+			return c < 0
+		}
+		return a.Timestamp > b.Timestamp
+	})
+	return row
+}
+
+/*
+Evaluator applies a filter to rows on the client, in scan order. It
+approximates the server for the filters known to Validate, except
+DependentColumnFilter which it rejects.
+*/
+type Evaluator struct {
+	root    Filter
+	pages   map[*Call]int64
+	regexps map[*Call]*regexp.Regexp
+	done    bool
+}
+
+/*
+NewEvaluator validates f and return an evaluator for a scan
+*/
+func NewEvaluator(f Filter) (*Evaluator, error) {
+	if err := Validate(f); err != nil {
+		return nil, err
+	}
+
+	ev := &Evaluator{
+		root:    f,
+		pages:   make(map[*Call]int64),
+		regexps: make(map[*Call]*regexp.Regexp),
+	}
+	if err := ev.prepare(f); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+func (ev *Evaluator) prepare(f Filter) error {
+	switch n := f.(type) {
+	case *List:
+		for _, child := range n.Filters {
+			if err := ev.prepare(child); err != nil {
+				return err
+			}
+		}
+	case *Unary:
+		return ev.prepare(n.Filter)
+	case *Call:
+		if n.Name == "DependentColumnFilter" {
+			return &ValidationError{Filter: n.Name, Msg: "not supported by the local evaluator"}
+		}
+		for _, arg := range n.Args {
+			if c, err := ParseComparator(arg.Bytes); arg.Kind == StringArg && err == nil && c.Type == RegexType {
+				ev.regexps[n] = regexp.MustCompile(string(c.Value))
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Done reports whether the filter ended the scan, no later row can match
+*/
+func (ev *Evaluator) Done() bool {
+	return ev.done
+}
+
+/*
+Filter return the part of row kept by the filter and whether the row is
+kept at all
+*/
+func (ev *Evaluator) Filter(row Record) (Record, bool) {
+	if ev.done {
+		return Record{Key: row.Key}, false
+	}
+
+	res := ev.eval(ev.root, row)
+	if !res.keep {
+		return Record{Key: row.Key}, false
+	}
+
+	out := Record{Key: row.Key}
+	for i, c := range row.Cells {
+		if res.cells[i] {
+			if res.keyOnly {
+				c.Value = nil
+			}
+			out.Cells = append(out.Cells, c)
+		}
+	}
+	if len(out.Cells) == 0 {
+		return out, false
+	}
+
+	ev.countPages(ev.root)
+	return out, true
+}
+
+/*
+Match reports whether the filter keeps any part of a single row, outside
+of a scan
+*/
+func Match(f Filter, row Record) (bool, error) {
+	ev, err := NewEvaluator(f)
+	if err != nil {
+		return false, err
+	}
+	_, ok := ev.Filter(row)
+	return ok, nil
+}
+
+type evalResult struct {
+	keep    bool
+	cells   []bool
+	dropped bool // some cell was dropped
+	keyOnly bool
+}
+
+func allCells(n int, keep bool) []bool {
+	cells := make([]bool, n)
+	for i := range cells {
+		cells[i] = keep
+	}
+	return cells
+}
+
+func (ev *Evaluator) eval(f Filter, row Record) evalResult {
+	switch n := f.(type) {
+	case *List:
+		return ev.evalList(n, row)
+	case *Unary:
+		res := ev.eval(n.Filter, row)
+		if !res.keep || res.dropped {
+			if n.Op == WhileOp {
+				ev.done = true
+			}
+			return evalResult{cells: allCells(len(row.Cells), false), dropped: true}
+		}
+		return res
+	case *Call:
+		return ev.evalCall(n, row)
+	}
+	return evalResult{cells: allCells(len(row.Cells), false)}
+}
+
+func (ev *Evaluator) evalList(l *List, row Record) evalResult {
+	and := l.Op == AndOp
+	res := evalResult{keep: and, cells: allCells(len(row.Cells), and)}
+
+	for _, child := range l.Filters {
+		r := ev.eval(child, row)
+		if and {
+			res.keep = res.keep && r.keep
+			res.keyOnly = res.keyOnly || r.keyOnly
+			for i := range res.cells {
+				res.cells[i] = res.cells[i] && r.cells[i]
+			}
+		} else {
+			res.keep = res.keep || r.keep
+			if r.keep {
+				res.keyOnly = res.keyOnly || r.keyOnly
+				for i := range res.cells {
+					res.cells[i] = res.cells[i] || r.cells[i]
+				}
+			}
+		}
+	}
+
+	if !res.keep {
+		res.cells = allCells(len(row.Cells), false)
+	}
+	for _, keep := range res.cells {
+		if !keep {
+			res.dropped = true
+		}
+	}
+	return res
+}
+
+/*
+rowResult keeps or drops all cells of the row
+*/
+func rowResult(row Record, keep bool) evalResult {
+	return evalResult{keep: keep, cells: allCells(len(row.Cells), keep), dropped: !keep}
+}
+
+/*
+cellResult keeps the cells for which keep is true
+*/
+func cellResult(row Record, keep func(i int, c Cell) bool) evalResult {
+	res := evalResult{keep: true, cells: make([]bool, len(row.Cells))}
+	for i, c := range row.Cells {
+		res.cells[i] = keep(i, c)
+		if !res.cells[i] {
+			res.dropped = true
+		}
+	}
+	return res
+}
+
+func (ev *Evaluator) evalCall(c *Call, row Record) evalResult {
+	a := c.Args
+	switch c.Name {
+	case "PrefixFilter":
+		return rowResult(row, bytes.HasPrefix(row.Key, a[0].Bytes))
+
+	case "RowFilter":
+		return rowResult(row, ev.compare(c, a[0].Op, a[1].Bytes, row.Key))
+
+	case "InclusiveStopFilter":
+		if bytes.Compare(row.Key, a[0].Bytes) > 0 {
+			ev.done = true
+			return rowResult(row, false)
+		}
+		return rowResult(row, true)
+
+	case "PageFilter":
+		return rowResult(row, ev.pages[c] < a[0].Int)
+
+	case "SingleColumnValueFilter", "SingleColumnValueExcludeFilter":
+		found, matched := false, false
+		for _, cell := range row.Cells {
+			if string(cell.Family) != string(a[0].Bytes) || string(cell.Qualifier) != string(a[1].Bytes) {
+				continue
+			}
+			found = true
+			if ev.compare(c, a[2].Op, a[3].Bytes, cell.Value) {
+				matched = true
+			}
+			if len(a) < 6 || a[5].Bool {
+				break // cells are newest first, only the latest version counts
+			}
+		}
+
+		keep := matched || (!found && !(len(a) >= 6 && a[4].Bool))
+		if !keep {
+			return rowResult(row, false)
+		}
+		if c.Name == "SingleColumnValueExcludeFilter" {
+			return cellResult(row, func(_ int, cell Cell) bool {
+				return string(cell.Family) != string(a[0].Bytes) || string(cell.Qualifier) != string(a[1].Bytes)
+			})
+		}
+		return rowResult(row, true)
+
+	case "FamilyFilter":
+		return cellResult(row, func(_ int, cell Cell) bool { return ev.compare(c, a[0].Op, a[1].Bytes, cell.Family) })
+
+	case "QualifierFilter":
+		return cellResult(row, func(_ int, cell Cell) bool { return ev.compare(c, a[0].Op, a[1].Bytes, cell.Qualifier) })
+
+	case "ValueFilter":
+		return cellResult(row, func(_ int, cell Cell) bool { return ev.compare(c, a[0].Op, a[1].Bytes, cell.Value) })
+
+	case "ColumnPrefixFilter":
+		return cellResult(row, func(_ int, cell Cell) bool { return bytes.HasPrefix(cell.Qualifier, a[0].Bytes) })
+
+	case "MultipleColumnPrefixFilter":
+		return cellResult(row, func(_ int, cell Cell) bool {
+			for _, p := range a {
+				if bytes.HasPrefix(cell.Qualifier, p.Bytes) {
+					return true
+				}
+			}
+			return false
+		})
+
+	case "ColumnRangeFilter":
+		return cellResult(row, func(_ int, cell Cell) bool {
+			lo, hi := bytes.Compare(cell.Qualifier, a[0].Bytes), bytes.Compare(cell.Qualifier, a[2].Bytes)
+			if len(a[0].Bytes) > 0 && (lo < 0 || (lo == 0 && !a[1].Bool)) {
+				return false
+			}
+			if len(a[2].Bytes) > 0 && (hi > 0 || (hi == 0 && !a[3].Bool)) {
+				return false
+			}
+			return true
+		})
+
+	case "TimestampsFilter":
+		return cellResult(row, func(_ int, cell Cell) bool {
+			for _, ts := range a {
+				if cell.Timestamp == ts.Int {
+					return true
+				}
+			}
+			return false
+		})
+
+	case "ColumnCountGetFilter":
+		return cellResult(row, func(i int, _ Cell) bool { return int64(i) < a[0].Int })
+
+	case "ColumnPaginationFilter":
+		return cellResult(row, func(i int, _ Cell) bool {
+			return int64(i) >= a[1].Int && int64(i) < a[1].Int+a[0].Int
+		})
+
+	case "FirstKeyOnlyFilter":
+		return cellResult(row, func(i int, _ Cell) bool { return i == 0 })
+
+	case "KeyOnlyFilter":
+		res := rowResult(row, true)
+		res.keyOnly = true
+		return res
+	}
+
+	panic(fmt.Sprintf("filter: no evaluation for %s", c.Name))
+}
+
+/*
+compare applies a compare operator and comparator to a value, the value
+is on the left side: RowFilter (<, 'binary:b') keeps rows before b
+*/
+func (ev *Evaluator) compare(c *Call, op CompareOp, arg []byte, value []byte) bool {
+	cmp, _ := ParseComparator(arg)
+
+	var r int
+	switch cmp.Type {
+	case BinaryType:
+		r = bytes.Compare(value, cmp.Value)
+	case BinaryPrefixType:
+		v := value
+		if len(v) > len(cmp.Value) {
+			v = v[:len(cmp.Value)]
+		}
+		r = bytes.Compare(v, cmp.Value)
+	case RegexType:
+		r = 1
+		if ev.regexps[c].Match(value) {
+			r = 0
+		}
+	case SubstringType:
+		r = 1
+		if strings.Contains(strings.ToLower(string(value)), strings.ToLower(string(cmp.Value))) {
+			r = 0
+		}
+	}
+
+	switch op {
+	case Less:
+		return r < 0
+	case LessOrEqual:
+		return r <= 0
+	case Equal:
+		return r == 0
+	case NotEqual:
+		return r != 0
+	case GreaterOrEqual:
+		return r >= 0
+	case Greater:
+		return r > 0
+	}
+	return false
+}
+
+/*
+countPages counts a kept row against the page filters of the tree
+*/
+func (ev *Evaluator) countPages(f Filter) {
+	switch n := f.(type) {
+	case *List:
+		for _, child := range n.Filters {
+			ev.countPages(child)
+		}
+	case *Unary:
+		ev.countPages(n.Filter)
+	case *Call:
+		if n.Name == "PageFilter" {
+			ev.pages[n]++
+		}
+	}
+}
@@ -0,0 +1,419 @@
+/*
+Package filter builds filter strings in the HBase filter language, as used
+by TScan.FilterString and the FilterString of thrift2 gets and scans.
+*/
+
+package filter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/chenjingping/goh/hbase1"
+	"github.com/chenjingping/goh/hbase2"
+)
+
+/*
+Filter is a node of a filter expression, String renders it in the filter
+language
+*/
+type Filter interface {
+	String() string
+	filter()
+}
+
+/*
+CompareOp is a comparison operator of the filter language
+*/
+type CompareOp string
+
+/*
+compare operators
+*/
+const (
+	Less           CompareOp = "<"
+	LessOrEqual    CompareOp = "<="
+	Equal          CompareOp = "="
+	NotEqual       CompareOp = "!="
+	GreaterOrEqual CompareOp = ">="
+	Greater        CompareOp = ">"
+)
+
+/*
+comparator types
+*/
+const (
+	BinaryType       = "binary"
+	BinaryPrefixType = "binaryprefix"
+	RegexType        = "regexstring"
+	SubstringType    = "substring"
+)
+
+/*
+Comparator is the comparator argument of the compare filters, written as
+'type:value'
+*/
+type Comparator struct {
+	Type  string
+	Value []byte
+}
+
+/*
+Binary compares lexicographically with value
+*/
+func Binary(value []byte) Comparator {
+	return Comparator{Type: BinaryType, Value: value}
+}
+
+/*
+BinaryPrefix compares the leading bytes with value
+*/
+func BinaryPrefix(value []byte) Comparator {
+	return Comparator{Type: BinaryPrefixType, Value: value}
+}
+
+/*
+Regex matches the regular expression, only with Equal and NotEqual
+*/
+func Regex(expr string) Comparator {
+	return Comparator{Type: RegexType, Value: []byte(expr)}
+}
+
+/*
+Substring matches values containing s, only with Equal and NotEqual
+*/
+func Substring(s string) Comparator {
+	return Comparator{Type: SubstringType, Value: []byte(s)}
+}
+
+/*
+Bytes return the comparator as the content of a quoted argument
+*/
+func (c Comparator) Bytes() []byte {
+	b := make([]byte, 0, len(c.Type)+1+len(c.Value))
+	b = append(b, c.Type...)
+	b = append(b, ':')
+	return append(b, c.Value...)
+}
+
+/*
+ArgKind is the kind of a filter argument
+*/
+type ArgKind int
+
+/*
+argument kinds
+*/
+const (
+	StringArg ArgKind = iota // quoted bytes
+	IntArg                   // integer
+	BoolArg                  // true or false
+	OpArg                    // compare operator
+)
+
+/*
+Arg is an argument of a filter call
+*/
+type Arg struct {
+	Kind  ArgKind
+	Bytes []byte
+	Int   int64
+	Bool  bool
+	Op    CompareOp
+}
+
+/*
+String renders the argument
+*/
+func (a Arg) String() string {
+	switch a.Kind {
+	case IntArg:
+		return strconv.FormatInt(a.Int, 10)
+	case BoolArg:
+		return strconv.FormatBool(a.Bool)
+	case OpArg:
+		return string(a.Op)
+	}
+	return Quote(a.Bytes)
+}
+
+/*
+Quote return b as a quoted string of the filter language
+*/
+func Quote(b []byte) string {
+	return "'" + strings.ReplaceAll(string(b), "'", "''") + "'"
+}
+
+func str(b []byte) Arg            { return Arg{Kind: StringArg, Bytes: b} }
+func num(n int64) Arg             { return Arg{Kind: IntArg, Int: n} }
+func boolean(b bool) Arg          { return Arg{Kind: BoolArg, Bool: b} }
+func op(o CompareOp) Arg          { return Arg{Kind: OpArg, Op: o} }
+func comparator(c Comparator) Arg { return str(c.Bytes()) }
+
+/*
+Call is a filter applied by name, like PrefixFilter ('abc')
+*/
+type Call struct {
+	Name string
+	Args []Arg
+}
+
+func (*Call) filter() {}
+
+/*
+String
+*/
+func (c *Call) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	sb.WriteString(" (")
+	for i, a := range c.Args {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(a.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+/*
+unary and binary operators
+*/
+const (
+	SkipOp  = "SKIP"
+	WhileOp = "WHILE"
+	AndOp   = "AND"
+	OrOp    = "OR"
+)
+
+/*
+Unary is a SKIP or WHILE wrapped filter
+*/
+type Unary struct {
+	Op     string
+	Filter Filter
+}
+
+func (*Unary) filter() {}
+
+/*
+String
+*/
+func (u *Unary) String() string {
+	return u.Op + " " + operand(u.Filter)
+}
+
+/*
+List is an AND or OR of filters
+*/
+type List struct {
+	Op      string
+	Filters []Filter
+}
+
+func (*List) filter() {}
+
+/*
+String
+*/
+func (l *List) String() string {
+	parts := make([]string, len(l.Filters))
+	for i, f := range l.Filters {
+		parts[i] = operand(f)
+	}
+	return strings.Join(parts, " "+l.Op+" ")
+}
+
+/*
+operand renders f, parenthesized when it is a list
+*/
+func operand(f Filter) string {
+	if l, ok := f.(*List); ok && len(l.Filters) > 1 {
+		return "(" + l.String() + ")"
+	}
+	return f.String()
+}
+
+/*
+Prefix keeps rows whose key starts with prefix
+*/
+func Prefix(prefix []byte) Filter {
+	return &Call{Name: "PrefixFilter", Args: []Arg{str(prefix)}}
+}
+
+/*
+Row compares the row key
+*/
+func Row(o CompareOp, c Comparator) Filter {
+	return &Call{Name: "RowFilter", Args: []Arg{op(o), comparator(c)}}
+}
+
+/*
+Family compares the column family
+*/
+func Family(o CompareOp, c Comparator) Filter {
+	return &Call{Name: "FamilyFilter", Args: []Arg{op(o), comparator(c)}}
+}
+
+/*
+Qualifier compares the column qualifier
+*/
+func Qualifier(o CompareOp, c Comparator) Filter {
+	return &Call{Name: "QualifierFilter", Args: []Arg{op(o), comparator(c)}}
+}
+
+/*
+Value compares the cell value
+*/
+func Value(o CompareOp, c Comparator) Filter {
+	return &Call{Name: "ValueFilter", Args: []Arg{op(o), comparator(c)}}
+}
+
+/*
+SingleColumnValue keeps rows whose family:qualifier value compares true,
+filterIfMissing drops rows without the column and latestOnly only checks
+the newest version
+*/
+func SingleColumnValue(family, qualifier string, o CompareOp, c Comparator, filterIfMissing, latestOnly bool) Filter {
+	return &Call{Name: "SingleColumnValueFilter", Args: []Arg{
+		str([]byte(family)), str([]byte(qualifier)), op(o), comparator(c), boolean(filterIfMissing), boolean(latestOnly),
+	}}
+}
+
+/*
+SingleColumnValueExclude is SingleColumnValue which leaves the tested
+column out of the result
+*/
+func SingleColumnValueExclude(family, qualifier string, o CompareOp, c Comparator, filterIfMissing, latestOnly bool) Filter {
+	return &Call{Name: "SingleColumnValueExcludeFilter", Args: []Arg{
+		str([]byte(family)), str([]byte(qualifier)), op(o), comparator(c), boolean(filterIfMissing), boolean(latestOnly),
+	}}
+}
+
+/*
+ColumnPrefix keeps columns whose qualifier starts with prefix
+*/
+func ColumnPrefix(prefix []byte) Filter {
+	return &Call{Name: "ColumnPrefixFilter", Args: []Arg{str(prefix)}}
+}
+
+/*
+MultipleColumnPrefix keeps columns whose qualifier starts with any prefix
+*/
+func MultipleColumnPrefix(prefixes ...[]byte) Filter {
+	args := make([]Arg, len(prefixes))
+	for i, p := range prefixes {
+		args[i] = str(p)
+	}
+	return &Call{Name: "MultipleColumnPrefixFilter", Args: args}
+}
+
+/*
+ColumnRange keeps columns whose qualifier is between min and max
+*/
+func ColumnRange(min []byte, minInclusive bool, max []byte, maxInclusive bool) Filter {
+	return &Call{Name: "ColumnRangeFilter", Args: []Arg{str(min), boolean(minInclusive), str(max), boolean(maxInclusive)}}
+}
+
+/*
+ColumnCountGet keeps the first limit columns of each row
+*/
+func ColumnCountGet(limit int64) Filter {
+	return &Call{Name: "ColumnCountGetFilter", Args: []Arg{num(limit)}}
+}
+
+/*
+ColumnPagination keeps limit columns of each row starting at offset
+*/
+func ColumnPagination(limit, offset int64) Filter {
+	return &Call{Name: "ColumnPaginationFilter", Args: []Arg{num(limit), num(offset)}}
+}
+
+/*
+Page keeps at most size rows per region server
+*/
+func Page(size int64) Filter {
+	return &Call{Name: "PageFilter", Args: []Arg{num(size)}}
+}
+
+/*
+InclusiveStop stops the scan after the row stop
+*/
+func InclusiveStop(stop []byte) Filter {
+	return &Call{Name: "InclusiveStopFilter", Args: []Arg{str(stop)}}
+}
+
+/*
+KeyOnly strips the values, leaving the keys
+*/
+func KeyOnly() Filter {
+	return &Call{Name: "KeyOnlyFilter"}
+}
+
+/*
+FirstKeyOnly keeps the first column of each row
+*/
+func FirstKeyOnly() Filter {
+	return &Call{Name: "FirstKeyOnlyFilter"}
+}
+
+/*
+Timestamps keeps cells with one of the timestamps
+*/
+func Timestamps(timestamps ...int64) Filter {
+	args := make([]Arg, len(timestamps))
+	for i, ts := range timestamps {
+		args[i] = num(ts)
+	}
+	return &Call{Name: "TimestampsFilter", Args: args}
+}
+
+/*
+Skip drops the whole row when f drops any of its cells
+*/
+func Skip(f Filter) Filter {
+	return &Unary{Op: SkipOp, Filter: f}
+}
+
+/*
+WhileMatch ends the scan at the first row f drops
+*/
+func WhileMatch(f Filter) Filter {
+	return &Unary{Op: WhileOp, Filter: f}
+}
+
+/*
+And keeps what all filters keep
+*/
+func And(filters ...Filter) Filter {
+	return &List{Op: AndOp, Filters: filters}
+}
+
+/*
+Or keeps what any filter keeps
+*/
+func Or(filters ...Filter) Filter {
+	return &List{Op: OrOp, Filters: filters}
+}
+
+/*
+Apply sets the filter string of a thrift1 scan
+*/
+func Apply(scan *hbase1.TScan, f Filter) {
+	scan.FilterString = hbase1.Text(f.String())
+}
+
+/*
+ApplyGet sets the filter string of a thrift2 get
+*/
+func ApplyGet(get *hbase2.TGet, f Filter) {
+	get.FilterString = []byte(f.String())
+}
+
+/*
+ApplyScan sets the filter string of a thrift2 scan
+*/
+func ApplyScan(scan *hbase2.TScan, f Filter) {
+	scan.FilterString = []byte(f.String())
+}
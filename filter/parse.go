@@ -0,0 +1,372 @@
+/*
+
+
+ */
+
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+SyntaxError reports a malformed filter string
+*/
+type SyntaxError struct {
+	Pos int    // byte offset in the filter string
+	Msg string // what is wrong
+}
+
+/*
+Error
+*/
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("filter: %s at offset %d", e.Msg, e.Pos)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind  tokenKind
+	pos   int
+	text  string
+	bytes []byte
+	num   int64
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokString:
+		return Quote(t.bytes)
+	}
+	return strconv.Quote(t.text)
+}
+
+type lexer struct {
+	src []byte
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, pos: start, text: "("}, nil
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, pos: start, text: ")"}, nil
+	case c == ',':
+		l.pos++
+		return token{kind: tokComma, pos: start, text: ","}, nil
+
+	case c == '\'':
+		var b []byte
+		l.pos++
+		for {
+			if l.pos >= len(l.src) {
+				return token{}, &SyntaxError{Pos: start, Msg: "unterminated quoted string"}
+			}
+			if l.src[l.pos] == '\'' {
+				if l.pos+1 < len(l.src) && l.src[l.pos+1] == '\'' {
+					b = append(b, '\'')
+					l.pos += 2
+					continue
+				}
+				l.pos++
+				return token{kind: tokString, pos: start, bytes: b}, nil
+			}
+			b = append(b, l.src[l.pos])
+			l.pos++
+		}
+
+	case c == '<' || c == '>' || c == '=' || c == '!':
+		l.pos++
+		if l.pos < len(l.src) && l.src[l.pos] == '=' && c != '=' {
+			l.pos++
+		}
+		text := string(l.src[start:l.pos])
+		if text == "!" {
+			return token{}, &SyntaxError{Pos: start, Msg: "invalid compare operator \"!\""}
+		}
+		return token{kind: tokOp, pos: start, text: text}, nil
+
+	case c == '-' || isDigit(c):
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		text := string(l.src[start:l.pos])
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return token{}, &SyntaxError{Pos: start, Msg: "invalid number " + strconv.Quote(text)}
+		}
+		return token{kind: tokInt, pos: start, text: text, num: n}, nil
+
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, pos: start, text: string(l.src[start:l.pos])}, nil
+	}
+
+	return token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", c)}
+}
+
+func isSpace(c byte) bool      { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
+func isDigit(c byte) bool      { return c >= '0' && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || (c|0x20 >= 'a' && c|0x20 <= 'z') }
+func isIdentPart(c byte) bool  { return isIdentStart(c) || isDigit(c) }
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) keyword(word string) bool {
+	return p.tok.kind == tokIdent && strings.EqualFold(p.tok.text, word)
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if p.tok.kind != kind {
+		return &SyntaxError{Pos: p.tok.pos, Msg: "expected " + what + ", found " + p.tok.describe()}
+	}
+	return p.advance()
+}
+
+/*
+Parse parses and validates a filter string
+*/
+func Parse(s string) (Filter, error) {
+	f, err := ParseSyntax(s)
+	if err != nil {
+		return nil, err
+	}
+	if err = Validate(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+/*
+ParseSyntax parses a filter string without checking filter names and
+arguments
+*/
+func ParseSyntax(s string) (Filter, error) {
+	p := &parser{lex: lexer{src: []byte(s)}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, &SyntaxError{Pos: p.tok.pos, Msg: "unexpected " + p.tok.describe()}
+	}
+	return f, nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	return p.parseList(OrOp, p.parseAnd)
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	return p.parseList(AndOp, p.parseUnary)
+}
+
+func (p *parser) parseList(op string, operand func() (Filter, error)) (Filter, error) {
+	f, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.keyword(op) {
+		return f, nil
+	}
+
+	list := &List{Op: op, Filters: []Filter{f}}
+	for p.keyword(op) {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+		if f, err = operand(); err != nil {
+			return nil, err
+		}
+		list.Filters = append(list.Filters, f)
+	}
+	return list, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	for _, op := range []string{SkipOp, WhileOp} {
+		if p.keyword(op) {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			f, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &Unary{Op: op, Filter: f}, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Filter, error) {
+	if p.tok.kind == tokLParen {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(tokRParen, "\")\""); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	if p.tok.kind != tokIdent || p.keyword(AndOp) || p.keyword(OrOp) {
+		return nil, &SyntaxError{Pos: p.tok.pos, Msg: "expected filter, found " + p.tok.describe()}
+	}
+
+	call := &Call{Name: p.tok.text}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokLParen, "\"(\" after "+call.Name); err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind != tokRParen {
+		if len(call.Args) > 0 {
+			if err := p.expect(tokComma, "\",\" or \")\""); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.parseArg()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+	}
+	return call, p.advance()
+}
+
+func (p *parser) parseArg() (Arg, error) {
+	t := p.tok
+	var arg Arg
+
+	switch {
+	case t.kind == tokString:
+		arg = str(t.bytes)
+	case t.kind == tokInt:
+		arg = num(t.num)
+	case t.kind == tokOp:
+		arg = op(CompareOp(t.text))
+	case p.keyword("true"):
+		arg = boolean(true)
+	case p.keyword("false"):
+		arg = boolean(false)
+	default:
+		return arg, &SyntaxError{Pos: t.pos, Msg: "expected argument, found " + t.describe()}
+	}
+	return arg, p.advance()
+}
+
+/*
+Format pretty-prints f over several lines, the result parses back to f
+*/
+func Format(f Filter) string {
+	var sb strings.Builder
+	format(&sb, f, 0)
+	return sb.String()
+}
+
+func format(sb *strings.Builder, f Filter, depth int) {
+	indent := strings.Repeat("    ", depth)
+
+	switch n := f.(type) {
+	case *List:
+		for i, child := range n.Filters {
+			if i > 0 {
+				sb.WriteString("\n")
+				sb.WriteString(indent)
+				sb.WriteString(n.Op)
+				sb.WriteString(" ")
+			} else {
+				sb.WriteString(indent)
+			}
+			formatOperand(sb, child, depth)
+		}
+	case *Unary:
+		sb.WriteString(indent)
+		sb.WriteString(n.Op)
+		sb.WriteString(" ")
+		formatOperand(sb, n.Filter, depth)
+	default:
+		sb.WriteString(indent)
+		sb.WriteString(f.String())
+	}
+}
+
+/*
+formatOperand writes f after an operator on the current line
+*/
+func formatOperand(sb *strings.Builder, f Filter, depth int) {
+	switch n := f.(type) {
+	case *List:
+		if len(n.Filters) < 2 {
+			sb.WriteString(n.String())
+			return
+		}
+		sb.WriteString("(\n")
+		format(sb, n, depth+1)
+		sb.WriteString("\n")
+		sb.WriteString(strings.Repeat("    ", depth))
+		sb.WriteString(")")
+	case *Unary:
+		sb.WriteString(n.Op)
+		sb.WriteString(" ")
+		formatOperand(sb, n.Filter, depth)
+	default:
+		sb.WriteString(f.String())
+	}
+}
@@ -0,0 +1,242 @@
+/*
+
+
+ */
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+ValidationError reports a filter which is well formed but not accepted
+by the server
+*/
+type ValidationError struct {
+	Filter string // name of the offending filter
+	Msg    string // what is wrong
+}
+
+/*
+Error
+*/
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("filter: %s: %s", e.Filter, e.Msg)
+}
+
+/*
+argument slots of a filter signature
+*/
+const (
+	slotString     = 'S'
+	slotInt        = 'I'
+	slotBool       = 'B'
+	slotOp         = 'O'
+	slotComparator = 'C'
+)
+
+/*
+filterSpec lists the accepted signatures of a filter, a trailing '+' or
+'*' repeats the last slot
+*/
+type filterSpec []string
+
+var specs = map[string]filterSpec{
+	"KeyOnlyFilter":                  {"", "B"},
+	"FirstKeyOnlyFilter":             {""},
+	"PrefixFilter":                   {"S"},
+	"ColumnPrefixFilter":             {"S"},
+	"MultipleColumnPrefixFilter":     {"S+"},
+	"ColumnCountGetFilter":           {"I"},
+	"PageFilter":                     {"I"},
+	"ColumnPaginationFilter":         {"II"},
+	"InclusiveStopFilter":            {"S"},
+	"TimestampsFilter":               {"I*"},
+	"RowFilter":                      {"OC"},
+	"FamilyFilter":                   {"OC"},
+	"QualifierFilter":                {"OC"},
+	"ValueFilter":                    {"OC"},
+	"DependentColumnFilter":          {"SS", "SSB", "SSBOC"},
+	"SingleColumnValueFilter":        {"SSOC", "SSOCBB"},
+	"SingleColumnValueExcludeFilter": {"SSOC", "SSOCBB"},
+	"ColumnRangeFilter":              {"SBSB"},
+}
+
+/*
+Names return the filter names known to the validator
+*/
+func Names() []string {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	return names
+}
+
+/*
+Validate checks filter names, argument counts and types, compare
+operators and comparators. Regular expressions are checked with Go's
+syntax, which accepts most but not all Java patterns.
+*/
+func Validate(f Filter) error {
+	switch n := f.(type) {
+	case *List:
+		if len(n.Filters) == 0 {
+			return &ValidationError{Filter: n.Op, Msg: "no operands"}
+		}
+		for _, child := range n.Filters {
+			if err := Validate(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *Unary:
+		if n.Filter == nil {
+			return &ValidationError{Filter: n.Op, Msg: "no operand"}
+		}
+		return Validate(n.Filter)
+	case *Call:
+		return validateCall(n)
+	}
+	return &ValidationError{Filter: fmt.Sprintf("%T", f), Msg: "unknown filter node"}
+}
+
+func validateCall(c *Call) error {
+	spec, ok := specs[c.Name]
+	if !ok {
+		return &ValidationError{Filter: c.Name, Msg: "unknown filter"}
+	}
+
+	var first error
+	for _, sig := range spec {
+		err := matchSignature(c, sig)
+		if err == nil {
+			return nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func matchSignature(c *Call, sig string) error {
+	slots := []byte(sig)
+	repeat := byte(0)
+	if n := len(slots); n > 0 && (slots[n-1] == '+' || slots[n-1] == '*') {
+		repeat = slots[n-1]
+		slots = slots[:n-1]
+	}
+
+	min, max := len(slots), len(slots)
+	if repeat == '*' {
+		min--
+		max = -1
+	} else if repeat == '+' {
+		max = -1
+	}
+	if len(c.Args) < min || (max >= 0 && len(c.Args) > max) {
+		return &ValidationError{Filter: c.Name, Msg: fmt.Sprintf("wrong number of arguments %d, %s", len(c.Args), describeSpec(specs[c.Name]))}
+	}
+
+	var lastOp CompareOp
+	for i, arg := range c.Args {
+		slot := slots[len(slots)-1]
+		if i < len(slots) {
+			slot = slots[i]
+		}
+
+		if err := checkSlot(c.Name, i, slot, arg, lastOp); err != nil {
+			return err
+		}
+		if arg.Kind == OpArg {
+			lastOp = arg.Op
+		}
+	}
+	return nil
+}
+
+func checkSlot(name string, i int, slot byte, arg Arg, lastOp CompareOp) error {
+	want := map[byte]ArgKind{
+		slotString:     StringArg,
+		slotInt:        IntArg,
+		slotBool:       BoolArg,
+		slotOp:         OpArg,
+		slotComparator: StringArg,
+	}[slot]
+
+	if arg.Kind != want {
+		return &ValidationError{Filter: name, Msg: fmt.Sprintf("argument %d must be %s, found %s", i+1, kindName(want), arg)}
+	}
+
+	switch slot {
+	case slotOp:
+		switch arg.Op {
+		case Less, LessOrEqual, Equal, NotEqual, GreaterOrEqual, Greater:
+		default:
+			return &ValidationError{Filter: name, Msg: fmt.Sprintf("invalid compare operator %q", arg.Op)}
+		}
+	case slotComparator:
+		c, err := ParseComparator(arg.Bytes)
+		if err != nil {
+			return &ValidationError{Filter: name, Msg: err.Error()}
+		}
+		if (c.Type == RegexType || c.Type == SubstringType) && lastOp != Equal && lastOp != NotEqual {
+			return &ValidationError{Filter: name, Msg: fmt.Sprintf("%s comparator only works with = and !=", c.Type)}
+		}
+	}
+	return nil
+}
+
+/*
+ParseComparator splits a 'type:value' comparator argument
+*/
+func ParseComparator(b []byte) (Comparator, error) {
+	i := bytes.IndexByte(b, ':')
+	if i < 0 {
+		return Comparator{}, fmt.Errorf("comparator %s is not of the form type:value", Quote(b))
+	}
+
+	c := Comparator{Type: strings.ToLower(string(b[:i])), Value: b[i+1:]}
+	switch c.Type {
+	case BinaryType, BinaryPrefixType, SubstringType:
+	case RegexType:
+		if _, err := regexp.Compile(string(c.Value)); err != nil {
+			return c, fmt.Errorf("invalid regular expression %s: %v", Quote(c.Value), err)
+		}
+	default:
+		return c, fmt.Errorf("unknown comparator type %q", c.Type)
+	}
+	return c, nil
+}
+
+func kindName(k ArgKind) string {
+	switch k {
+	case IntArg:
+		return "a number"
+	case BoolArg:
+		return "true or false"
+	case OpArg:
+		return "a compare operator"
+	}
+	return "a quoted string"
+}
+
+func describeSpec(spec filterSpec) string {
+	forms := make([]string, len(spec))
+	for i, sig := range spec {
+		switch {
+		case strings.HasSuffix(sig, "+"):
+			forms[i] = fmt.Sprintf("at least %d", len(sig)-1)
+		case strings.HasSuffix(sig, "*"):
+			forms[i] = fmt.Sprintf("at least %d", len(sig)-2)
+		default:
+			forms[i] = fmt.Sprint(len(sig))
+		}
+	}
+	return "expected " + strings.Join(forms, " or ")
+}
@@ -0,0 +1,204 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+GuardConfig protects tables from the mutations and admin calls of a
+client. Table patterns use path.Match syntax, as in "prod_*".
+*/
+type GuardConfig struct {
+	Allow      []string                                 // tables mutations and admin calls may touch, empty allows every table
+	Deny       []string                                 // tables mutations and admin calls may not touch
+	Production []string                                 // tables DeleteTable needs a confirmation token for
+	ReadOnly   bool                                     // reject every mutation and admin call
+	DryRun     bool                                     // log mutations and admin calls instead of sending them
+	Logf       func(format string, args ...interface{}) // dry-run log, log.Printf when nil
+}
+
+/*
+deleteTokenTTL is how long a token of IssueDeleteToken stays valid
+*/
+const deleteTokenTTL = 5 * time.Minute
+
+type deleteToken struct {
+	table   string
+	expires time.Time
+}
+
+/*
+IssueDeleteToken return a random token for DeleteTableConfirmed, valid
+for one deletion of the table within five minutes. It is meant to be
+shown to the operator and typed back, a token issued for another table
+is refused.
+*/
+func (client *HClient) IssueDeleteToken(tableName string) (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:])
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.tokens == nil {
+		client.tokens = make(map[string]deleteToken)
+	}
+	now := time.Now()
+	for k, t := range client.tokens {
+		if now.After(t.expires) {
+			delete(client.tokens, k)
+		}
+	}
+	client.tokens[token] = deleteToken{table: tableName, expires: now.Add(deleteTokenTTL)}
+	return token, nil
+}
+
+/*
+redeemDeleteToken consumes the token, it reports whether it was issued
+for the table and has not expired
+*/
+func (client *HClient) redeemDeleteToken(tableName, token string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	t, ok := client.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(client.tokens, token)
+	return t.table == tableName && time.Now().Before(t.expires)
+}
+
+/*
+SetGuard guards the mutations and admin calls of the client, nil removes
+the guard. In dry-run, calls return zero values and no error.
+*/
+func (client *HClient) SetGuard(g *GuardConfig) error {
+	if g != nil {
+		for _, patterns := range [][]string{g.Allow, g.Deny, g.Production} {
+			for _, p := range patterns {
+				if _, err := path.Match(p, ""); err != nil {
+					return fmt.Errorf("goh: guard pattern %q: %v", p, err)
+				}
+			}
+		}
+		c := *g
+		g = &c
+	}
+
+	client.mu.Lock()
+	client.guard = g
+	client.mu.Unlock()
+	return nil
+}
+
+func matchAny(patterns []string, tableName string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, tableName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+guardCall checks a call against the guard, skip is true when a dry-run
+swallows it
+*/
+func (client *HClient) guardCall(op string, tables []string, kind int, describe func() string) (skip bool, err error) {
+	client.mu.Lock()
+	g := client.guard
+	client.mu.Unlock()
+
+	if g == nil || (kind != opWrite && kind != opAdmin) {
+		return false, nil
+	}
+
+	if g.ReadOnly {
+		return false, fmt.Errorf("%w: %s on %s", ErrReadOnly, op, strings.Join(tables, ", "))
+	}
+	for _, name := range tables {
+		if matchAny(g.Deny, name) || (len(g.Allow) > 0 && !matchAny(g.Allow, name)) {
+			return false, fmt.Errorf("%w: %s on %s", ErrProtectedTable, op, name)
+		}
+		if op == "DeleteTable" && matchAny(g.Production, name) {
+			return false, fmt.Errorf("%w: table %s, use IssueDeleteToken and DeleteTableConfirmed", ErrConfirmationRequired, name)
+		}
+	}
+
+	if g.DryRun {
+		logf := g.Logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		if describe != nil {
+			logf("goh: dry-run %s %s on %s: %s", client.addr, op, strings.Join(tables, ", "), describe())
+		} else {
+			logf("goh: dry-run %s %s on %s", client.addr, op, strings.Join(tables, ", "))
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+/*
+describeOps lists the columns a list of mutations puts and deletes
+*/
+func describeOps(mutations []*hbase1.Mutation) string {
+	parts := make([]string, len(mutations))
+	for i, m := range mutations {
+		if m.IsDelete {
+			parts[i] = "delete " + string(m.Column)
+		} else {
+			parts[i] = fmt.Sprintf("put %s (%d bytes)", m.Column, len(m.Value))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeMutations(row []byte, mutations []*hbase1.Mutation) string {
+	return fmt.Sprintf("row %q: %s", row, describeOps(mutations))
+}
+
+func describeBatches(batches []*hbase1.BatchMutation) string {
+	parts := make([]string, len(batches))
+	for i, b := range batches {
+		parts[i] = describeMutations(b.Row, b.Mutations)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func describeIncrements(increments []*hbase1.TIncrement) string {
+	parts := make([]string, len(increments))
+	for i, inc := range increments {
+		parts[i] = fmt.Sprintf("row %q: increment %s by %d", inc.Row, inc.Column, inc.Ammount)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func describeFamilies(families []*ColumnDescriptor) string {
+	names := make([]string, len(families))
+	for i, f := range families {
+		names[i] = f.Name
+	}
+	return "families " + strings.Join(names, ", ")
+}
+
+func describeTs(timestamp int64) string {
+	return fmt.Sprintf(" at %d", timestamp)
+}
@@ -2,8 +2,10 @@ package goh
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"net/url"
+	"sync"
 
 	"github.com/chenjingping/thrift/lib/go/thrift"
 	"github.com/chenjingping/goh/hbase1"
@@ -19,8 +21,26 @@ type HClient struct {
 	ProtocolFactory thrift.TProtocolFactory
 	hbase           *hbase1.HbaseClient
 	state           int
+
+	mu       sync.Mutex
+	breaker  *CircuitBreaker
+	limits   map[string]*tableLimit
+	cache    *RowCache
+	scanners map[int32]string
+	guard    *GuardConfig
+	tokens   map[string]deleteToken
 }
 
+/*
+operation kinds, used to pick the rate limit and safety rules of a call
+*/
+const (
+	opRead  = iota // reads rows or cells
+	opWrite        // mutates rows or cells
+	opAdmin        // changes tables
+	opMeta         // reads table metadata
+)
+
 /*
 Dail return hbase client struct
 
@@ -129,6 +149,76 @@ func newClient(addr string, protocol int, trans thrift.TTransport) (*HClient, er
 	return client, nil
 }
 
+/*
+call runs fn after the guard, breaker and rate limits of the client
+admitted the operation op
+*/
+func (client *HClient) call(op string, tableName string, kind int, fn func() error) error {
+	return client.callTables(op, []string{tableName}, kind, fn)
+}
+
+/*
+callWrite is call for mutations, describe tells the dry-run log what
+would be sent
+*/
+func (client *HClient) callWrite(op string, tableName string, describe func() string, fn func() error) error {
+	return client.callDescribed(op, []string{tableName}, opWrite, describe, fn)
+}
+
+/*
+callTables is call for operations touching several tables
+*/
+func (client *HClient) callTables(op string, tables []string, kind int, fn func() error) error {
+	return client.callDescribed(op, tables, kind, nil, fn)
+}
+
+func (client *HClient) callDescribed(op string, tables []string, kind int, describe func() string, fn func() error) error {
+	if skip, err := client.guardCall(op, tables, kind, describe); skip || err != nil {
+		return err
+	}
+
+	breaker, err := client.admit(tables, kind)
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	if breaker != nil {
+		breaker.Done(err)
+	}
+	return err
+}
+
+func (client *HClient) rememberScanner(id int32, tableName string) {
+	client.mu.Lock()
+	if client.scanners == nil {
+		client.scanners = make(map[int32]string)
+	}
+	client.scanners[id] = tableName
+	client.mu.Unlock()
+}
+
+func (client *HClient) forgetScanner(id int32) {
+	client.mu.Lock()
+	delete(client.scanners, id)
+	client.mu.Unlock()
+}
+
+func (client *HClient) scannerTable(id int32) string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.scanners[id]
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
 /*
 Open connection
 */
@@ -162,7 +252,9 @@ func (client *HClient) Close() error {
  *  - TableName: name of the table
  */
 func (client *HClient) EnableTable(tableName string) error {
-	return client.hbase.EnableTable(hbase1.Bytes(tableName))
+	return client.call("EnableTable", tableName, opAdmin, func() error {
+		return client.hbase.EnableTable(hbase1.Bytes(tableName))
+	})
 }
 
 /**
@@ -173,7 +265,9 @@ func (client *HClient) EnableTable(tableName string) error {
  *  - TableName: name of the table
  */
 func (client *HClient) DisableTable(tableName string) (err error) {
-	return client.hbase.DisableTable(hbase1.Bytes(tableName))
+	return client.call("DisableTable", tableName, opAdmin, func() error {
+		return client.hbase.DisableTable(hbase1.Bytes(tableName))
+	})
 }
 
 /**
@@ -183,7 +277,11 @@ func (client *HClient) DisableTable(tableName string) (err error) {
  *  - TableName: name of the table to check
  */
 func (client *HClient) IsTableEnabled(tableName string) (ret bool, err error) {
-	return client.hbase.IsTableEnabled(hbase1.Bytes(tableName))
+	err = client.call("IsTableEnabled", tableName, opMeta, func() (e error) {
+		ret, e = client.hbase.IsTableEnabled(hbase1.Bytes(tableName))
+		return
+	})
+	return
 }
 
 /**
@@ -191,7 +289,9 @@ func (client *HClient) IsTableEnabled(tableName string) (ret bool, err error) {
  *  - TableNameOrRegionName
  */
 func (client *HClient) Compact(tableNameOrRegionName string) (err error) {
-	return client.hbase.Compact(hbase1.Bytes(tableNameOrRegionName))
+	return client.call("Compact", tableNameOrRegionName, opAdmin, func() error {
+		return client.hbase.Compact(hbase1.Bytes(tableNameOrRegionName))
+	})
 }
 
 /**
@@ -199,7 +299,9 @@ func (client *HClient) Compact(tableNameOrRegionName string) (err error) {
  *  - TableNameOrRegionName
  */
 func (client *HClient) MajorCompact(tableNameOrRegionName string) (err error) {
-	return client.hbase.MajorCompact(hbase1.Bytes(tableNameOrRegionName))
+	return client.call("MajorCompact", tableNameOrRegionName, opAdmin, func() error {
+		return client.hbase.MajorCompact(hbase1.Bytes(tableNameOrRegionName))
+	})
 }
 
 /**
@@ -211,7 +313,11 @@ func (client *HClient) MajorCompact(tableNameOrRegionName string) (err error) {
  *  - TableName: table name
  */
 func (client *HClient) GetTableNames() (tables []string, err error) {
-	ret, e1 := client.hbase.GetTableNames()
+	var ret []hbase1.Text
+	e1 := client.call("GetTableNames", "", opMeta, func() (e error) {
+		ret, e = client.hbase.GetTableNames()
+		return
+	})
 	if err = checkHbaseError(nil, e1); err != nil {
 		return
 	}
@@ -229,7 +335,11 @@ func (client *HClient) GetTableNames() (tables []string, err error) {
  *  - TableName: table name
  */
 func (client *HClient) GetColumnDescriptors(tableName string) (columns map[string]*ColumnDescriptor, err error) {
-	ret, e1 := client.hbase.GetColumnDescriptors(hbase1.Text(tableName))
+	var ret map[string]*hbase1.ColumnDescriptor
+	e1 := client.call("GetColumnDescriptors", tableName, opMeta, func() (e error) {
+		ret, e = client.hbase.GetColumnDescriptors(hbase1.Text(tableName))
+		return
+	})
 	if err = checkHbaseError(nil, e1); err != nil {
 		return
 	}
@@ -246,11 +356,15 @@ func (client *HClient) GetColumnDescriptors(tableName string) (columns map[strin
  *  - TableName: table name
  */
 func (client *HClient) GetTableRegions(tableName string) (regions []*TRegionInfo, err error) {
-	if ret, e1 := client.hbase.GetTableRegions(hbase1.Text(tableName)); e1 == nil {
-		return toRegionList(ret), nil
-	} else {
+	var ret []*hbase1.TRegionInfo
+	e1 := client.call("GetTableRegions", tableName, opMeta, func() (e error) {
+		ret, e = client.hbase.GetTableRegions(hbase1.Text(tableName))
+		return
+	})
+	if e1 != nil {
 		return nil, e1
 	}
+	return toRegionList(ret), nil
 }
 
 /**
@@ -261,7 +375,8 @@ func (client *HClient) GetTableRegions(tableName string) (regions []*TRegionInfo
  *
  * @throws IllegalArgument if an input parameter is invalid
  *
- * @throws AlreadyExists if the table name already exists
+ * @return true and an error wrapping ErrTableExists if the table
+ * name already exists
  *
  * Parameters:
  *  - TableName: name of table to create
@@ -270,11 +385,32 @@ func (client *HClient) GetTableRegions(tableName string) (regions []*TRegionInfo
 func (client *HClient) CreateTable(tableName string, columnFamilies []*ColumnDescriptor) (exists bool, err error) {
 	columns := toHbaseColList(columnFamilies)
 
-	if err = client.hbase.CreateTable(hbase1.Text(tableName), columns); err != nil {
-		return
+	describe := func() string { return describeFamilies(columnFamilies) }
+	err = client.callDescribed("CreateTable", []string{tableName}, opAdmin, describe, func() error {
+		return client.hbase.CreateTable(hbase1.Text(tableName), columns)
+	})
+	if isTableExists(err) {
+		return true, fmt.Errorf("%w: %s", ErrTableExists, tableName)
 	}
-	exists = (err != nil)
-	return
+	return false, err
+}
+
+/**
+ * Create a table unless it exists, an existing table is left as it is
+ * whatever its column families.
+ *
+ * @return true if the table was created
+ *
+ * Parameters:
+ *  - TableName: name of table to create
+ *  - ColumnFamilies: list of column family descriptors
+ */
+func (client *HClient) CreateTableIfNotExists(tableName string, columnFamilies []*ColumnDescriptor) (created bool, err error) {
+	exists, err := client.CreateTable(tableName, columnFamilies)
+	if exists {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 /**
@@ -287,7 +423,26 @@ func (client *HClient) CreateTable(tableName string, columnFamilies []*ColumnDes
  *  - TableName: name of table to delete
  */
 func (client *HClient) DeleteTable(tableName string) (err error) {
-	return client.hbase.DeleteTable(hbase1.Text(tableName))
+	return client.call("DeleteTable", tableName, opAdmin, func() error {
+		return client.hbase.DeleteTable(hbase1.Text(tableName))
+	})
+}
+
+/**
+ * Deletes a table matching the production patterns of the guard
+ *
+ * Parameters:
+ *  - TableName: name of table to delete
+ *  - Token: a token IssueDeleteToken returned for TableName
+ */
+func (client *HClient) DeleteTableConfirmed(tableName string, token string) (err error) {
+	if !client.redeemDeleteToken(tableName, token) {
+		return fmt.Errorf("%w: table %s, the token is unknown, expired or issued for another table", ErrConfirmationRequired, tableName)
+	}
+
+	return client.call("DeleteTableConfirmed", tableName, opAdmin, func() error {
+		return client.hbase.DeleteTable(hbase1.Text(tableName))
+	})
 }
 
 /**
@@ -301,9 +456,15 @@ func (client *HClient) DeleteTable(tableName string) (err error) {
  *  - Row: row key
  *  - Column: column name
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadCells, which returns goh cells.
  */
 func (client *HClient) Get(tableName string, row []byte, column string, attributes map[string]string) (data []*hbase1.TCell, err error) {
-	return client.hbase.Get(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), toHbaseTextMap(attributes))
+	err = client.call("Get", tableName, opRead, func() (e error) {
+		data, e = client.hbase.Get(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -318,9 +479,15 @@ func (client *HClient) Get(tableName string, row []byte, column string, attribut
  *  - Column: column name
  *  - NumVersions: number of versions to retrieve
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadVersions, which returns goh cells.
  */
 func (client *HClient) GetVer(tableName string, row []byte, column string, numVersions int32, attributes map[string]string) (data []*hbase1.TCell, err error) {
-	return client.hbase.GetVer(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), numVersions, toHbaseTextMap(attributes))
+	err = client.call("GetVer", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetVer(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), numVersions, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -337,9 +504,15 @@ func (client *HClient) GetVer(tableName string, row []byte, column string, numVe
  *  - Timestamp: timestamp
  *  - NumVersions: number of versions to retrieve
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadVersionsTs, which returns goh cells.
  */
 func (client *HClient) GetVerTs(tableName string, row []byte, column string, timestamp int64, numVersions int32, attributes map[string]string) (data []*hbase1.TCell, err error) {
-	return client.hbase.GetVerTs(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), timestamp, numVersions, toHbaseTextMap(attributes))
+	err = client.call("GetVerTs", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetVerTs(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), timestamp, numVersions, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -352,9 +525,17 @@ func (client *HClient) GetVerTs(tableName string, row []byte, column string, tim
  *  - TableName: name of table
  *  - Row: row key
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRow, which returns goh rows.
  */
 func (client *HClient) GetRow(tableName string, row []byte, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRow(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextMap(attributes))
+	return client.readRow(tableName, row, nil, attributes, func(attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
+		err = client.call("GetRow", tableName, opRead, func() (e error) {
+			data, e = client.hbase.GetRow(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextMap(attributes))
+			return
+		})
+		return
+	})
 }
 
 /**
@@ -368,9 +549,17 @@ func (client *HClient) GetRow(tableName string, row []byte, attributes map[strin
  *  - Row: row key
  *  - Columns: List of columns to return, null for all columns
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRowWithColumns, which returns goh rows.
  */
 func (client *HClient) GetRowWithColumns(tableName string, row []byte, columns []string, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRowWithColumns(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextList(columns), toHbaseTextMap(attributes))
+	return client.readRow(tableName, row, columns, attributes, func(attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
+		err = client.call("GetRowWithColumns", tableName, opRead, func() (e error) {
+			data, e = client.hbase.GetRowWithColumns(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextList(columns), toHbaseTextMap(attributes))
+			return
+		})
+		return
+	})
 }
 
 /**
@@ -384,9 +573,15 @@ func (client *HClient) GetRowWithColumns(tableName string, row []byte, columns [
  *  - Row: row key
  *  - Timestamp: timestamp
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRowTs, which returns goh rows.
  */
 func (client *HClient) GetRowTs(tableName string, row []byte, timestamp int64, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRowTs(hbase1.Text(tableName), hbase1.Text(row), timestamp, toHbaseTextMap(attributes))
+	err = client.call("GetRowTs", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetRowTs(hbase1.Text(tableName), hbase1.Text(row), timestamp, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -401,9 +596,15 @@ func (client *HClient) GetRowTs(tableName string, row []byte, timestamp int64, a
  *  - Columns: List of columns to return, null for all columns
  *  - Timestamp
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRowWithColumnsTs, which returns goh rows.
  */
 func (client *HClient) GetRowWithColumnsTs(tableName string, row []byte, columns []string, timestamp int64, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRowWithColumnsTs(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+	err = client.call("GetRowWithColumnsTs", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetRowWithColumnsTs(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -416,9 +617,15 @@ func (client *HClient) GetRowWithColumnsTs(tableName string, row []byte, columns
  *  - TableName: name of table
  *  - Rows: row keys
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRows, which returns goh rows.
  */
 func (client *HClient) GetRows(tableName string, rows [][]byte, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRows(hbase1.Text(tableName), toHbaseTextListFromByte(rows), toHbaseTextMap(attributes))
+	err = client.call("GetRows", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetRows(hbase1.Text(tableName), toHbaseTextListFromByte(rows), toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -432,13 +639,19 @@ func (client *HClient) GetRows(tableName string, rows [][]byte, attributes map[s
  *  - Rows: row keys
  *  - Columns: List of columns to return, null for all columns
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRowsWithColumns, which returns goh rows.
  */
 func (client *HClient) GetRowsWithColumns(tableName string, rows [][]byte, columns []string, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
 	if err = client.Open(); err != nil {
 		return nil, err
 	}
 
-	return client.hbase.GetRowsWithColumns(hbase1.Text(tableName), toHbaseTextListFromByte(rows), toHbaseTextList(columns), toHbaseTextMap(attributes))
+	err = client.call("GetRowsWithColumns", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetRowsWithColumns(hbase1.Text(tableName), toHbaseTextListFromByte(rows), toHbaseTextList(columns), toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -452,9 +665,15 @@ func (client *HClient) GetRowsWithColumns(tableName string, rows [][]byte, colum
  *  - Rows: row keys
  *  - Timestamp: timestamp
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRowsTs, which returns goh rows.
  */
 func (client *HClient) GetRowsTs(tableName string, rows [][]byte, timestamp int64, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRowsTs(hbase1.Text(tableName), toHbaseTextListFromByte(rows), timestamp, toHbaseTextMap(attributes))
+	err = client.call("GetRowsTs", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetRowsTs(hbase1.Text(tableName), toHbaseTextListFromByte(rows), timestamp, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -469,9 +688,15 @@ func (client *HClient) GetRowsTs(tableName string, rows [][]byte, timestamp int6
  *  - Columns: List of columns to return, null for all columns
  *  - Timestamp
  *  - Attributes: Get attributes
+ *
+ * Deprecated: use ReadRowsWithColumnsTs, which returns goh rows.
  */
 func (client *HClient) GetRowsWithColumnsTs(tableName string, rows [][]byte, columns []string, timestamp int64, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.GetRowsWithColumnsTs(hbase1.Text(tableName), toHbaseTextListFromByte(rows), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+	err = client.call("GetRowsWithColumnsTs", tableName, opRead, func() (e error) {
+		data, e = client.hbase.GetRowsWithColumnsTs(hbase1.Text(tableName), toHbaseTextListFromByte(rows), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /*
@@ -487,7 +712,12 @@ func (client *HClient) GetRowsWithColumnsTs(tableName string, rows [][]byte, col
  *  - Attributes: Mutation attributes
  */
 func (client *HClient) MutateRow(tableName string, row []byte, mutations []*hbase1.Mutation, attributes map[string]string) error {
-	return client.hbase.MutateRow(hbase1.Text(tableName), hbase1.Text(row), mutations, toHbaseTextMap(attributes))
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return describeMutations(row, mutations) }
+	return client.callWrite("MutateRow", tableName, describe, func() error {
+		return client.hbase.MutateRow(hbase1.Text(tableName), hbase1.Text(row), mutations, toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -504,7 +734,12 @@ func (client *HClient) MutateRow(tableName string, row []byte, mutations []*hbas
  *  - Attributes: Mutation attributes
  */
 func (client *HClient) MutateRowTs(tableName string, row []byte, mutations []*hbase1.Mutation, timestamp int64, attributes map[string]string) error {
-	return client.hbase.MutateRowTs(hbase1.Text(tableName), hbase1.Text(row), mutations, timestamp, toHbaseTextMap(attributes))
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return describeMutations(row, mutations) + describeTs(timestamp) }
+	return client.callWrite("MutateRowTs", tableName, describe, func() error {
+		return client.hbase.MutateRowTs(hbase1.Text(tableName), hbase1.Text(row), mutations, timestamp, toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -519,7 +754,16 @@ func (client *HClient) MutateRowTs(tableName string, row []byte, mutations []*hb
  *  - Attributes: Mutation attributes
  */
 func (client *HClient) MutateRows(tableName string, rowBatches []*hbase1.BatchMutation, attributes map[string]string) error {
-	return client.hbase.MutateRows(hbase1.Text(tableName), rowBatches, toHbaseTextMap(attributes))
+	defer func() {
+		for _, b := range rowBatches {
+			client.invalidateRow(tableName, b.Row)
+		}
+	}()
+
+	describe := func() string { return describeBatches(rowBatches) }
+	return client.callWrite("MutateRows", tableName, describe, func() error {
+		return client.hbase.MutateRows(hbase1.Text(tableName), rowBatches, toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -535,7 +779,16 @@ func (client *HClient) MutateRows(tableName string, rowBatches []*hbase1.BatchMu
  *  - Attributes: Mutation attributes
  */
 func (client *HClient) MutateRowsTs(tableName string, rowBatches []*hbase1.BatchMutation, timestamp int64, attributes map[string]string) error {
-	return client.hbase.MutateRowsTs(hbase1.Text(tableName), rowBatches, timestamp, toHbaseTextMap(attributes))
+	defer func() {
+		for _, b := range rowBatches {
+			client.invalidateRow(tableName, b.Row)
+		}
+	}()
+
+	describe := func() string { return describeBatches(rowBatches) + describeTs(timestamp) }
+	return client.callWrite("MutateRowsTs", tableName, describe, func() error {
+		return client.hbase.MutateRowsTs(hbase1.Text(tableName), rowBatches, timestamp, toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -548,7 +801,14 @@ func (client *HClient) MutateRowsTs(tableName string, rowBatches []*hbase1.Batch
  *  - Value: amount to increment by
  */
 func (client *HClient) AtomicIncrement(tableName string, row []byte, column string, value int64) (v int64, err error) {
-	return client.hbase.AtomicIncrement(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), value)
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return fmt.Sprintf("row %q: increment %s by %d", row, column, value) }
+	err = client.callWrite("AtomicIncrement", tableName, describe, func() (e error) {
+		v, e = client.hbase.AtomicIncrement(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), value)
+		return
+	})
+	return
 }
 
 /**
@@ -561,7 +821,12 @@ func (client *HClient) AtomicIncrement(tableName string, row []byte, column stri
  *  - Attributes: Delete attributes
  */
 func (client *HClient) DeleteAll(tableName string, row []byte, column string, attributes map[string]string) error {
-	return client.hbase.DeleteAll(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), toHbaseTextMap(attributes))
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return fmt.Sprintf("row %q: delete %s", row, column) }
+	return client.callWrite("DeleteAll", tableName, describe, func() error {
+		return client.hbase.DeleteAll(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -576,7 +841,12 @@ func (client *HClient) DeleteAll(tableName string, row []byte, column string, at
  *  - Attributes: Delete attributes
  */
 func (client *HClient) DeleteAllTs(tableName string, row []byte, column string, timestamp int64, attributes map[string]string) error {
-	return client.hbase.DeleteAllTs(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), timestamp, toHbaseTextMap(attributes))
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return fmt.Sprintf("row %q: delete %s", row, column) + describeTs(timestamp) }
+	return client.callWrite("DeleteAllTs", tableName, describe, func() error {
+		return client.hbase.DeleteAllTs(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), timestamp, toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -588,7 +858,12 @@ func (client *HClient) DeleteAllTs(tableName string, row []byte, column string,
  *  - Attributes: Delete attributes
  */
 func (client *HClient) DeleteAllRow(tableName string, row []byte, attributes map[string]string) error {
-	return client.hbase.DeleteAllRow(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextMap(attributes))
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return fmt.Sprintf("row %q: delete all", row) }
+	return client.callWrite("DeleteAllRow", tableName, describe, func() error {
+		return client.hbase.DeleteAllRow(hbase1.Text(tableName), hbase1.Text(row), toHbaseTextMap(attributes))
+	})
 }
 
 /**
@@ -601,7 +876,12 @@ func (client *HClient) DeleteAllRow(tableName string, row []byte, attributes map
  *  - Increment: The single increment to apply
  */
 func (client *HClient) Increment(increment *hbase1.TIncrement) error {
-	return client.hbase.Increment(increment)
+	defer client.invalidateRow(string(increment.Table), increment.Row)
+
+	describe := func() string { return describeIncrements([]*hbase1.TIncrement{increment}) }
+	return client.callWrite("Increment", string(increment.Table), describe, func() error {
+		return client.hbase.Increment(increment)
+	})
 }
 
 /**
@@ -609,7 +889,16 @@ func (client *HClient) Increment(increment *hbase1.TIncrement) error {
  *  - Increments: The list of increments
  */
 func (client *HClient) IncrementRows(increments []*hbase1.TIncrement) error {
-	return client.hbase.IncrementRows(increments)
+	tables := make([]string, 0, 1)
+	for _, inc := range increments {
+		defer client.invalidateRow(string(inc.Table), inc.Row)
+		tables = appendUnique(tables, string(inc.Table))
+	}
+
+	describe := func() string { return describeIncrements(increments) }
+	return client.callDescribed("IncrementRows", tables, opWrite, describe, func() error {
+		return client.hbase.IncrementRows(increments)
+	})
 }
 
 /**
@@ -623,7 +912,40 @@ func (client *HClient) IncrementRows(increments []*hbase1.TIncrement) error {
  *  - Attributes: Delete attributes
  */
 func (client *HClient) DeleteAllRowTs(tableName string, row []byte, timestamp int64, attributes map[string]string) error {
-	return client.hbase.DeleteAllRowTs(hbase1.Text(tableName), hbase1.Text(row), timestamp, toHbaseTextMap(attributes))
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string { return fmt.Sprintf("row %q: delete all", row) + describeTs(timestamp) }
+	return client.callWrite("DeleteAllRowTs", tableName, describe, func() error {
+		return client.hbase.DeleteAllRowTs(hbase1.Text(tableName), hbase1.Text(row), timestamp, toHbaseTextMap(attributes))
+	})
+}
+
+/**
+ * Atomically checks if a row/family/qualifier value matches the expected
+ * value. If it does, it adds the corresponding mutation operation for put.
+ *
+ * @return true if the new put was executed, false otherwise
+ *
+ * Parameters:
+ *  - TableName: name of table
+ *  - Row: row key
+ *  - Column: column name
+ *  - Value: the expected value for the column parameter, if nil
+ * the check is for the non-existence of the column in question
+ *  - Mput: mutation for the put
+ *  - Attributes: Mutation attributes
+ */
+func (client *HClient) CheckAndPut(tableName string, row []byte, column string, value []byte, mput *hbase1.Mutation, attributes map[string]string) (ok bool, err error) {
+	defer client.invalidateRow(tableName, row)
+
+	describe := func() string {
+		return fmt.Sprintf("row %q: if %s matches, ", row, column) + describeOps([]*hbase1.Mutation{mput})
+	}
+	err = client.callWrite("CheckAndPut", tableName, describe, func() (e error) {
+		ok, e = client.hbase.CheckAndPut(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(column), hbase1.Text(value), mput, toHbaseTextMap(attributes))
+		return
+	})
+	return
 }
 
 /**
@@ -636,9 +958,18 @@ func (client *HClient) DeleteAllRowTs(tableName string, row []byte, timestamp in
  *  - Attributes: Scan attributes
  */
 func (client *HClient) ScannerOpenWithScan(tableName string, scan *TScan, attributes map[string]string) (id int32, err error) {
-	ret, e1 := client.hbase.ScannerOpenWithScan(hbase1.Text(tableName), toHbaseTScan(scan), toHbaseTextMap(attributes))
+	var ret hbase1.ScannerID
+	err = client.call("ScannerOpenWithScan", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.ScannerOpenWithScan(hbase1.Text(tableName), toHbaseTScan(scan), toHbaseTextMap(attributes))
+		return
+	})
+	if err != nil {
+		return
+	}
 
-	return int32(ret), e1
+	id = int32(ret)
+	client.rememberScanner(id, tableName)
+	return
 }
 
 /**
@@ -657,9 +988,18 @@ func (client *HClient) ScannerOpenWithScan(tableName string, scan *TScan, attrib
  *  - Attributes: Scan attributes
  */
 func (client *HClient) ScannerOpen(tableName string, startRow []byte, columns []string, attributes map[string]string) (id int32, err error) {
-	ret, e1 := client.hbase.ScannerOpen(hbase1.Text(tableName), hbase1.Text(startRow), toHbaseTextList(columns), toHbaseTextMap(attributes))
-	
-	return int32(ret), e1
+	var ret hbase1.ScannerID
+	err = client.call("ScannerOpen", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.ScannerOpen(hbase1.Text(tableName), hbase1.Text(startRow), toHbaseTextList(columns), toHbaseTextMap(attributes))
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	id = int32(ret)
+	client.rememberScanner(id, tableName)
+	return
 }
 
 /**
@@ -681,9 +1021,18 @@ func (client *HClient) ScannerOpen(tableName string, startRow []byte, columns []
  *  - Attributes: Scan attributes
  */
 func (client *HClient) ScannerOpenWithStop(tableName string, startRow []byte, stopRow []byte, columns []string, attributes map[string]string) (id int32, err error) {
-	ret, e1 := client.hbase.ScannerOpenWithStop(hbase1.Text(tableName), hbase1.Text(startRow), hbase1.Text(stopRow), toHbaseTextList(columns), toHbaseTextMap(attributes))
-	
-	return int32(ret), e1
+	var ret hbase1.ScannerID
+	err = client.call("ScannerOpenWithStop", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.ScannerOpenWithStop(hbase1.Text(tableName), hbase1.Text(startRow), hbase1.Text(stopRow), toHbaseTextList(columns), toHbaseTextMap(attributes))
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	id = int32(ret)
+	client.rememberScanner(id, tableName)
+	return
 }
 
 /**
@@ -699,9 +1048,18 @@ func (client *HClient) ScannerOpenWithStop(tableName string, startRow []byte, st
  *  - Attributes: Scan attributes
  */
 func (client *HClient) ScannerOpenWithPrefix(tableName string, startAndPrefix []byte, columns []string, attributes map[string]string) (id int32, err error) {
-	ret, e1 := client.hbase.ScannerOpenWithPrefix(hbase1.Text(tableName), hbase1.Text(startAndPrefix), toHbaseTextList(columns), toHbaseTextMap(attributes))
+	var ret hbase1.ScannerID
+	err = client.call("ScannerOpenWithPrefix", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.ScannerOpenWithPrefix(hbase1.Text(tableName), hbase1.Text(startAndPrefix), toHbaseTextList(columns), toHbaseTextMap(attributes))
+		return
+	})
+	if err != nil {
+		return
+	}
 
-	return int32(ret), e1
+	id = int32(ret)
+	client.rememberScanner(id, tableName)
+	return
 }
 
 /**
@@ -722,9 +1080,18 @@ func (client *HClient) ScannerOpenWithPrefix(tableName string, startAndPrefix []
  *  - Attributes: Scan attributes
  */
 func (client *HClient) ScannerOpenTs(tableName string, startRow []byte, columns []string, timestamp int64, attributes map[string]string) (id int32, err error) {
-	ret, e1 := client.hbase.ScannerOpenTs(hbase1.Text(tableName), hbase1.Text(startRow), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+	var ret hbase1.ScannerID
+	err = client.call("ScannerOpenTs", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.ScannerOpenTs(hbase1.Text(tableName), hbase1.Text(startRow), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+		return
+	})
+	if err != nil {
+		return
+	}
 
-	return int32(ret), e1
+	id = int32(ret)
+	client.rememberScanner(id, tableName)
+	return
 }
 
 /**
@@ -748,9 +1115,18 @@ func (client *HClient) ScannerOpenTs(tableName string, startRow []byte, columns
  *  - Attributes: Scan attributes
  */
 func (client *HClient) ScannerOpenWithStopTs(tableName string, startRow []byte, stopRow []byte, columns []string, timestamp int64, attributes map[string]string) (id int32, err error) {
-	ret, e1 := client.hbase.ScannerOpenWithStopTs(hbase1.Text(tableName), hbase1.Text(startRow), hbase1.Text(stopRow), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
-	
-	return int32(ret), e1
+	var ret hbase1.ScannerID
+	err = client.call("ScannerOpenWithStopTs", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.ScannerOpenWithStopTs(hbase1.Text(tableName), hbase1.Text(startRow), hbase1.Text(stopRow), toHbaseTextList(columns), timestamp, toHbaseTextMap(attributes))
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	id = int32(ret)
+	client.rememberScanner(id, tableName)
+	return
 }
 
 /**
@@ -767,9 +1143,15 @@ func (client *HClient) ScannerOpenWithStopTs(tableName string, startRow []byte,
  *
  * Parameters:
  *  - Id: id of a scanner returned by scannerOpen
+ *
+ * Deprecated: use ScannerRead, which returns goh rows.
  */
 func (client *HClient) ScannerGet(id int32) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.ScannerGet(hbase1.ScannerID(id))
+	err = client.call("ScannerGet", client.scannerTable(id), opRead, func() (e error) {
+		data, e = client.hbase.ScannerGet(hbase1.ScannerID(id))
+		return
+	})
+	return
 }
 
 /**
@@ -787,9 +1169,15 @@ func (client *HClient) ScannerGet(id int32) (data []*hbase1.TRowResult_, err err
  * Parameters:
  *  - Id: id of a scanner returned by scannerOpen
  *  - NbRows: number of results to return
+ *
+ * Deprecated: use ScannerReadList, which returns goh rows.
  */
 func (client *HClient) ScannerGetList(id int32, nbRows int32) (data []*hbase1.TRowResult_, err error) {
-	return client.hbase.ScannerGetList(hbase1.ScannerID(id), nbRows)
+	err = client.call("ScannerGetList", client.scannerTable(id), opRead, func() (e error) {
+		data, e = client.hbase.ScannerGetList(hbase1.ScannerID(id), nbRows)
+		return
+	})
+	return
 }
 
 /**
@@ -801,7 +1189,11 @@ func (client *HClient) ScannerGetList(id int32, nbRows int32) (data []*hbase1.TR
  *  - Id: id of a scanner returned by scannerOpen
  */
 func (client *HClient) ScannerClose(id int32) error {
-	return client.hbase.ScannerClose(hbase1.ScannerID(id))
+	defer client.forgetScanner(id)
+
+	return client.call("ScannerClose", client.scannerTable(id), opMeta, func() error {
+		return client.hbase.ScannerClose(hbase1.ScannerID(id))
+	})
 }
 
 /**
@@ -815,7 +1207,11 @@ func (client *HClient) ScannerClose(id int32) error {
  *  - Family: column name
  */
 func (client *HClient) GetRowOrBefore(tableName string, row string, family string) (data []*hbase1.TCell, err error) {
-	ret, e1 := client.hbase.GetRowOrBefore(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(family))
+	var ret []*hbase1.TCell
+	e1 := client.call("GetRowOrBefore", tableName, opRead, func() (e error) {
+		ret, e = client.hbase.GetRowOrBefore(hbase1.Text(tableName), hbase1.Text(row), hbase1.Text(family))
+		return
+	})
 	if err = checkHbaseError(nil, e1); err != nil {
 		return
 	}
@@ -834,7 +1230,11 @@ func (client *HClient) GetRowOrBefore(tableName string, row string, family strin
  *  - Row: row key
  */
 func (client *HClient) GetRegionInfo(row string) (region *TRegionInfo, err error) {
-	ret, e1 := client.hbase.GetRegionInfo(hbase1.Text(row))
+	var ret *hbase1.TRegionInfo
+	e1 := client.call("GetRegionInfo", "", opMeta, func() (e error) {
+		ret, e = client.hbase.GetRegionInfo(hbase1.Text(row))
+		return
+	})
 	if err = checkHbaseError(nil, e1); err != nil {
 		return
 	}
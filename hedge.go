@@ -0,0 +1,250 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+ErrNoPools is returned by NewHedgedReader when it is given no pool
+*/
+var ErrNoPools = errors.New("goh: hedged reader needs at least one pool")
+
+/*
+HedgePolicy tells when a duplicate read is sent
+*/
+type HedgePolicy struct {
+	Delay      time.Duration // fixed hedge delay, also the floor when Percentile is set
+	Percentile float64       // hedge after this percentile (0..1) of recent latencies, 0 uses Delay only
+	Samples    int           // latencies kept for the percentile, default 1000
+}
+
+/*
+HedgeStats counts the hedged reads
+*/
+type HedgeStats struct {
+	Calls     int64 // reads issued
+	Hedged    int64 // reads which sent a duplicate
+	HedgeWins int64 // reads answered by the duplicate first
+}
+
+/*
+HedgedReader sends read-only calls to a primary pool and, when no answer
+came within the hedge delay, a duplicate to the next pool (or a second
+connection of the same pool). The first answer wins, the slower call
+runs to completion in the background and its result is discarded.
+*/
+type HedgedReader struct {
+	policy HedgePolicy
+	pools  []*Pool
+	next   uint32
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	pos       int
+	cached    time.Duration
+	observed  int
+
+	calls     int64
+	hedged    int64
+	hedgeWins int64
+}
+
+/*
+NewHedgedReader return a hedged reader over pools, one pool per endpoint
+*/
+func NewHedgedReader(policy HedgePolicy, pools ...*Pool) (*HedgedReader, error) {
+	if len(pools) == 0 {
+		return nil, ErrNoPools
+	}
+	if policy.Samples <= 0 {
+		policy.Samples = 1000
+	}
+	return &HedgedReader{
+		policy:    policy,
+		pools:     pools,
+		latencies: make([]time.Duration, 0, policy.Samples),
+	}, nil
+}
+
+/*
+Stats return the hedging counters
+*/
+func (h *HedgedReader) Stats() HedgeStats {
+	return HedgeStats{
+		Calls:     atomic.LoadInt64(&h.calls),
+		Hedged:    atomic.LoadInt64(&h.hedged),
+		HedgeWins: atomic.LoadInt64(&h.hedgeWins),
+	}
+}
+
+/*
+delay return the time to wait for the primary before hedging
+*/
+func (h *HedgedReader) delay() time.Duration {
+	if h.policy.Percentile <= 0 {
+		return h.policy.Delay
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cached < h.policy.Delay {
+		return h.policy.Delay
+	}
+	return h.cached
+}
+
+/*
+observe records the latency of a primary call, the percentile is
+recomputed every hundred samples
+*/
+func (h *HedgedReader) observe(d time.Duration) {
+	if h.policy.Percentile <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < h.policy.Samples {
+		h.latencies = append(h.latencies, d)
+	} else {
+		h.latencies[h.pos] = d
+		h.pos = (h.pos + 1) % h.policy.Samples
+	}
+
+	h.observed++
+	if h.observed%100 != 0 && h.cached != 0 {
+		return
+	}
+
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted)-1) * h.policy.Percentile)
+	h.cached = sorted[idx]
+}
+
+/*
+pick return the primary pool and the pool used for the duplicate, the
+same one when there is a single pool
+*/
+func (h *HedgedReader) pick() (*Pool, *Pool) {
+	n := uint32(len(h.pools))
+	if n == 1 {
+		return h.pools[0], h.pools[0]
+	}
+	i := atomic.AddUint32(&h.next, 1) % n
+	return h.pools[i], h.pools[(i+1)%n]
+}
+
+type hedgeResult[T any] struct {
+	value  T
+	err    error
+	hedged bool
+}
+
+/*
+hedge runs fn against the primary pool and, after the hedge delay,
+against the hedge pool, returning the first successful answer
+*/
+func hedge[T any](h *HedgedReader, fn func(client *HClient) (T, error)) (T, error) {
+	atomic.AddInt64(&h.calls, 1)
+	primary, secondary := h.pick()
+
+	ch := make(chan hedgeResult[T], 2)
+	run := func(pool *Pool, hedged bool) {
+		start := time.Now()
+		client, err := pool.Get()
+		if err != nil {
+			ch <- hedgeResult[T]{err: err, hedged: hedged}
+			return
+		}
+
+		v, err := fn(client)
+		pool.Put(client, err)
+		if err == nil && !hedged {
+			h.observe(time.Since(start))
+		}
+		ch <- hedgeResult[T]{value: v, err: err, hedged: hedged}
+	}
+
+	go run(primary, false)
+	pending, sent := 1, false
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+
+	var last hedgeResult[T]
+	for {
+		select {
+		case r := <-ch:
+			pending--
+			if r.err == nil {
+				if r.hedged {
+					atomic.AddInt64(&h.hedgeWins, 1)
+				}
+				return r.value, nil
+			}
+			last = r
+			if pending == 0 {
+				return last.value, last.err
+			}
+
+		case <-timer.C:
+			if !sent {
+				sent = true
+				pending++
+				atomic.AddInt64(&h.hedged, 1)
+				go run(secondary, true)
+			}
+		}
+	}
+}
+
+/*
+Get is HClient.Get with hedging
+*/
+func (h *HedgedReader) Get(tableName string, row []byte, column string, attributes map[string]string) ([]*hbase1.TCell, error) {
+	return hedge(h, func(client *HClient) ([]*hbase1.TCell, error) {
+		return client.Get(tableName, row, column, attributes)
+	})
+}
+
+/*
+GetRow is HClient.GetRow with hedging
+*/
+func (h *HedgedReader) GetRow(tableName string, row []byte, attributes map[string]string) ([]*hbase1.TRowResult_, error) {
+	return hedge(h, func(client *HClient) ([]*hbase1.TRowResult_, error) {
+		return client.GetRow(tableName, row, attributes)
+	})
+}
+
+/*
+GetRowWithColumns is HClient.GetRowWithColumns with hedging
+*/
+func (h *HedgedReader) GetRowWithColumns(tableName string, row []byte, columns []string, attributes map[string]string) ([]*hbase1.TRowResult_, error) {
+	return hedge(h, func(client *HClient) ([]*hbase1.TRowResult_, error) {
+		return client.GetRowWithColumns(tableName, row, columns, attributes)
+	})
+}
+
+/*
+GetRows is HClient.GetRows with hedging
+*/
+func (h *HedgedReader) GetRows(tableName string, rows [][]byte, attributes map[string]string) ([]*hbase1.TRowResult_, error) {
+	return hedge(h, func(client *HClient) ([]*hbase1.TRowResult_, error) {
+		return client.GetRows(tableName, rows, attributes)
+	})
+}
@@ -0,0 +1,112 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+/*
+HyperLogLog estimates the number of distinct values added to it in 2^p
+bytes, with a standard error of about 1.04/sqrt(2^p)
+*/
+type HyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+/*
+NewHyperLogLog return an empty sketch, precision is clamped to 4..18
+*/
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	if precision < 4 {
+		precision = 4
+	}
+	if precision > 18 {
+		precision = 18
+	}
+	return &HyperLogLog{p: precision, registers: make([]uint8, 1<<precision)}
+}
+
+/*
+hash64 is fnv-1a followed by the splitmix64 finalizer, fnv alone leaves
+the high bits of short keys poorly mixed
+*/
+func hash64(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+/*
+Add adds a value
+*/
+func (h *HyperLogLog) Add(value []byte) {
+	x := hash64(value)
+	i := x >> (64 - h.p)
+	rank := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+/*
+Merge adds the values of o, both must have the same precision
+*/
+func (h *HyperLogLog) Merge(o *HyperLogLog) error {
+	if h.p != o.p {
+		return errors.New("goh: merging sketches of different precisions")
+	}
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+/*
+Count return the estimated number of distinct values
+*/
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small sets
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -6,6 +6,7 @@ package goh
 import (
 	"fmt"
 	"strconv"
+	"github.com/chenjingping/goh/filter"
 	"github.com/chenjingping/goh/hbase1"
 )
 
@@ -320,6 +321,13 @@ type TScan struct {
 	BatchSize    int32    "batchSize"    // 7
 }
 
+/*
+SetFilter sets FilterString from a filter built with the filter package
+*/
+func (scan *TScan) SetFilter(f filter.Filter) {
+	scan.FilterString = f.String()
+}
+
 func toHbaseTScan(scan *TScan) *hbase1.TScan {
 	if scan == nil { return nil }
 	
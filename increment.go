@@ -0,0 +1,324 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+ErrCoalescerClosed is returned by Increment on a closed coalescer
+*/
+var ErrCoalescerClosed = errors.New("goh: increment coalescer is closed")
+
+/*
+CoalescerConfig sets when an IncrementCoalescer flushes
+*/
+type CoalescerConfig struct {
+	MaxKeys       int           // flush when this many counters are pending, 10000 by default
+	BatchSize     int           // counters per IncrementRows call, 1000 by default
+	FlushInterval time.Duration // flush in the background this often, 1s by default, negative for never
+	CloseTimeout  time.Duration // Close retries a failed flush for this long, 10s by default
+}
+
+/*
+CoalescerStats counts the increments of an IncrementCoalescer
+*/
+type CoalescerStats struct {
+	Increments int64 // Increment calls
+	Sent       int64 // counters written by IncrementRows
+	Batches    int64 // IncrementRows calls
+	Errors     int64 // failed calls
+	Pending    int64 // counters waiting for a flush
+	Lost       int64 // increments dropped: refused by the server or left by Close
+}
+
+/*
+Ratio return the increments received per counter written, 0 before the
+first write
+*/
+func (s CoalescerStats) Ratio() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Increments) / float64(s.Sent)
+}
+
+type pendingIncrement struct {
+	inc   *hbase1.TIncrement
+	calls int64
+}
+
+/*
+IncrementCoalescer sums increments per table, row and column in memory
+and writes the sums with IncrementRows, every FlushInterval or when
+MaxKeys counters are pending.
+
+A batch failing for the cluster being unreachable and the batches after
+it are merged back and retried by the next flush; a batch the server
+refuses, for a missing table say, is dropped and counted in Lost. The
+error is returned by the call which flushed or, for a background flush,
+by the next call. IncrementRows is not atomic, a batch the server applied
+in part before failing is counted again on retry.
+Increments are lost when the process dies before they are flushed, at
+most FlushInterval or MaxKeys counters of them, and when Close cannot
+flush within CloseTimeout.
+*/
+type IncrementCoalescer struct {
+	client *HClient
+	conf   CoalescerConfig
+
+	flushMu sync.Mutex // serializes flushes
+
+	mu      sync.Mutex
+	pending map[string]*pendingIncrement
+	err     error
+	closed  bool
+
+	stats CoalescerStats
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+/*
+NewIncrementCoalescer return a coalescer writing through client
+*/
+func NewIncrementCoalescer(client *HClient, conf CoalescerConfig) *IncrementCoalescer {
+	if conf.MaxKeys <= 0 {
+		conf.MaxKeys = 10000
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 1000
+	}
+	if conf.FlushInterval == 0 {
+		conf.FlushInterval = time.Second
+	}
+	if conf.CloseTimeout <= 0 {
+		conf.CloseTimeout = 10 * time.Second
+	}
+
+	ic := &IncrementCoalescer{
+		client:  client,
+		conf:    conf,
+		pending: make(map[string]*pendingIncrement),
+	}
+	if conf.FlushInterval > 0 {
+		ic.stop = make(chan struct{})
+		ic.done = make(chan struct{})
+		go ic.flusher()
+	}
+	return ic
+}
+
+func (ic *IncrementCoalescer) flusher() {
+	defer close(ic.done)
+
+	t := time.NewTicker(ic.conf.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ic.stop:
+			return
+		case <-t.C:
+			if err := ic.Flush(); err != nil {
+				ic.mu.Lock()
+				if ic.err == nil {
+					ic.err = err
+				}
+				ic.mu.Unlock()
+			}
+		}
+	}
+}
+
+func incrementKey(tableName string, row []byte, column string) string {
+	return tableName + "\x00" + string(row) + "\x00" + column
+}
+
+/*
+Increment adds amount to the counter at column (family:qualifier) of the
+row, flushing when MaxKeys counters are pending
+*/
+func (ic *IncrementCoalescer) Increment(tableName string, row []byte, column string, amount int64) error {
+	if family, _ := SplitColumn(column); family == "" || strings.IndexByte(column, ':') < 0 {
+		return fmt.Errorf("goh: column %q is not family:qualifier", column)
+	}
+
+	ic.mu.Lock()
+	if ic.closed {
+		ic.mu.Unlock()
+		return ErrCoalescerClosed
+	}
+	if err := ic.err; err != nil {
+		ic.err = nil
+		ic.mu.Unlock()
+		return err
+	}
+
+	atomic.AddInt64(&ic.stats.Increments, 1)
+	key := incrementKey(tableName, row, column)
+	if p, ok := ic.pending[key]; ok {
+		p.inc.Ammount += amount
+		p.calls++
+	} else {
+		ic.pending[key] = &pendingIncrement{inc: NewTIncrement(tableName, row, column, amount), calls: 1}
+	}
+	n := len(ic.pending)
+	atomic.StoreInt64(&ic.stats.Pending, int64(n))
+	ic.mu.Unlock()
+
+	if n >= ic.conf.MaxKeys {
+		return ic.Flush()
+	}
+	return nil
+}
+
+/*
+Flush writes the pending counters, it return the first error met
+*/
+func (ic *IncrementCoalescer) Flush() error {
+	ic.flushMu.Lock()
+	defer ic.flushMu.Unlock()
+
+	ic.mu.Lock()
+	pending := ic.pending
+	ic.pending = make(map[string]*pendingIncrement)
+	atomic.StoreInt64(&ic.stats.Pending, 0)
+	ic.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// sorted, so batches touch neighbouring rows
+	keys := make([]string, 0, len(pending))
+	for key, p := range pending {
+		if p.inc.Ammount == 0 {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var first error
+	for len(keys) > 0 {
+		n := ic.conf.BatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		batch := make([]*hbase1.TIncrement, n)
+		for i, key := range keys[:n] {
+			batch[i] = pending[key].inc
+		}
+
+		err := ic.client.IncrementRows(batch)
+		atomic.AddInt64(&ic.stats.Batches, 1)
+		switch {
+		case err == nil:
+			atomic.AddInt64(&ic.stats.Sent, int64(n))
+		case isTransient(err):
+			atomic.AddInt64(&ic.stats.Errors, 1)
+			ic.requeue(pending, keys)
+			return err
+		default:
+			// retrying would fail again and hold back the counters after it
+			atomic.AddInt64(&ic.stats.Errors, 1)
+			for _, key := range keys[:n] {
+				atomic.AddInt64(&ic.stats.Lost, pending[key].calls)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		keys = keys[n:]
+	}
+	return first
+}
+
+/*
+requeue merges the counters of keys back into the pending ones
+*/
+func (ic *IncrementCoalescer) requeue(pending map[string]*pendingIncrement, keys []string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	for _, key := range keys {
+		p := pending[key]
+		if q, ok := ic.pending[key]; ok {
+			q.inc.Ammount += p.inc.Ammount
+			q.calls += p.calls
+		} else {
+			ic.pending[key] = p
+		}
+	}
+	atomic.StoreInt64(&ic.stats.Pending, int64(len(ic.pending)))
+}
+
+/*
+Close stops the background flush and flushes the pending counters,
+retrying for CloseTimeout. The increments still pending then are dropped
+and counted in Lost.
+*/
+func (ic *IncrementCoalescer) Close() error {
+	ic.mu.Lock()
+	if ic.closed {
+		ic.mu.Unlock()
+		return nil
+	}
+	ic.closed = true
+	ic.err = nil
+	ic.mu.Unlock()
+
+	if ic.stop != nil {
+		close(ic.stop)
+		<-ic.done
+	}
+
+	deadline := time.Now().Add(ic.conf.CloseTimeout)
+	wait := 100 * time.Millisecond
+	err := ic.Flush()
+	for isTransient(err) && time.Now().Add(wait).Before(deadline) {
+		time.Sleep(wait)
+		if wait < time.Second {
+			wait *= 2
+		}
+		err = ic.Flush()
+	}
+	if err == nil {
+		return nil
+	}
+
+	ic.mu.Lock()
+	for _, p := range ic.pending {
+		atomic.AddInt64(&ic.stats.Lost, p.calls)
+	}
+	ic.pending = make(map[string]*pendingIncrement)
+	atomic.StoreInt64(&ic.stats.Pending, 0)
+	ic.mu.Unlock()
+	return err
+}
+
+/*
+Stats return the counters of the coalescer
+*/
+func (ic *IncrementCoalescer) Stats() CoalescerStats {
+	return CoalescerStats{
+		Increments: atomic.LoadInt64(&ic.stats.Increments),
+		Sent:       atomic.LoadInt64(&ic.stats.Sent),
+		Batches:    atomic.LoadInt64(&ic.stats.Batches),
+		Errors:     atomic.LoadInt64(&ic.stats.Errors),
+		Pending:    atomic.LoadInt64(&ic.stats.Pending),
+		Lost:       atomic.LoadInt64(&ic.stats.Lost),
+	}
+}
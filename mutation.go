@@ -0,0 +1,370 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chenjingping/goh/hbase1"
+	"github.com/chenjingping/goh/hbase2"
+)
+
+type mutationOp struct {
+	family    string
+	qualifier string
+	value     []byte
+	isDelete  bool
+	isFamily  bool // a family delete, qualifier is empty
+	timestamp int64
+}
+
+/*
+RowMutation builds the puts and deletes of one row:
+
+	m := goh.NewRowMutation(row).
+		Put("cf:name", []byte("bob")).
+		PutTyped("cf:age", 42).
+		Timestamp(ts).DeleteColumn("cf:old").
+		DeleteFamily("tmp")
+	err := client.ApplyRowMutation("users", m, nil)
+
+The first error, a malformed column or an unsupported value, is kept and
+returned when the mutation is emitted
+*/
+type RowMutation struct {
+	row       []byte
+	ops       []mutationOp
+	timestamp int64
+	wal       bool
+	err       error
+}
+
+/*
+NewRowMutation return an empty mutation of row, written to the WAL at
+server time
+*/
+func NewRowMutation(row []byte) *RowMutation {
+	return &RowMutation{row: row, wal: true}
+}
+
+/*
+Row return the row key
+*/
+func (m *RowMutation) Row() []byte {
+	return m.row
+}
+
+/*
+Len return the number of operations
+*/
+func (m *RowMutation) Len() int {
+	return len(m.ops)
+}
+
+/*
+Err return the first error met while building
+*/
+func (m *RowMutation) Err() error {
+	return m.err
+}
+
+/*
+Timestamp sets the timestamp of the operations added after it, 0 for
+server time
+*/
+func (m *RowMutation) Timestamp(timestamp int64) *RowMutation {
+	m.timestamp = timestamp
+	return m
+}
+
+/*
+SetWriteToWAL sets whether the mutation is written to the WAL, skipping
+it is faster but loses the data when a region server dies
+*/
+func (m *RowMutation) SetWriteToWAL(wal bool) *RowMutation {
+	m.wal = wal
+	return m
+}
+
+func (m *RowMutation) add(column string, value []byte, isDelete, family bool) *RowMutation {
+	if m.err != nil {
+		return m
+	}
+
+	f, q := SplitColumn(column)
+	switch {
+	case f == "":
+		m.err = fmt.Errorf("goh: column %q has no family", column)
+	case family && strings.IndexByte(column, ':') >= 0:
+		m.err = fmt.Errorf("goh: family %q contains a colon", column)
+	case !family && strings.IndexByte(column, ':') < 0:
+		m.err = fmt.Errorf("goh: column %q is not family:qualifier", column)
+	default:
+		m.ops = append(m.ops, mutationOp{
+			family:    f,
+			qualifier: q,
+			value:     value,
+			isDelete:  isDelete,
+			isFamily:  family,
+			timestamp: m.timestamp,
+		})
+	}
+	return m
+}
+
+/*
+Put sets the value of a family:qualifier column
+*/
+func (m *RowMutation) Put(column string, value []byte) *RowMutation {
+	return m.add(column, value, false, false)
+}
+
+/*
+PutTyped sets a column from a string, []byte, bool, integer or float,
+encoded as text the way ScanHbaseColumns reads it back
+*/
+func (m *RowMutation) PutTyped(column string, value interface{}) *RowMutation {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	case bool:
+		b = strconv.AppendBool(nil, v)
+	case int:
+		b = strconv.AppendInt(nil, int64(v), 10)
+	case int8:
+		b = strconv.AppendInt(nil, int64(v), 10)
+	case int16:
+		b = strconv.AppendInt(nil, int64(v), 10)
+	case int32:
+		b = strconv.AppendInt(nil, int64(v), 10)
+	case int64:
+		b = strconv.AppendInt(nil, v, 10)
+	case uint:
+		b = strconv.AppendUint(nil, uint64(v), 10)
+	case uint8:
+		b = strconv.AppendUint(nil, uint64(v), 10)
+	case uint16:
+		b = strconv.AppendUint(nil, uint64(v), 10)
+	case uint32:
+		b = strconv.AppendUint(nil, uint64(v), 10)
+	case uint64:
+		b = strconv.AppendUint(nil, v, 10)
+	case float32:
+		b = strconv.AppendFloat(nil, float64(v), 'g', -1, 32)
+	case float64:
+		b = strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case fmt.Stringer:
+		b = []byte(v.String())
+	default:
+		if m.err == nil {
+			m.err = fmt.Errorf("goh: can't put value of type %T in column %q", value, column)
+		}
+		return m
+	}
+	return m.add(column, b, false, false)
+}
+
+/*
+DeleteColumn deletes every version of a family:qualifier column, up to
+the current timestamp when one is set
+*/
+func (m *RowMutation) DeleteColumn(column string) *RowMutation {
+	return m.add(column, nil, true, false)
+}
+
+/*
+DeleteFamily deletes every column of a family
+*/
+func (m *RowMutation) DeleteFamily(family string) *RowMutation {
+	return m.add(family, nil, true, true)
+}
+
+/*
+Validate checks the families used against the families of the table, as
+returned by GetColumnDescriptors
+*/
+func (m *RowMutation) Validate(families map[string]*ColumnDescriptor) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	for _, op := range m.ops {
+		if !hasFamily(families, op.family) {
+			return fmt.Errorf("%w: %s", ErrUnknownFamily, op.family)
+		}
+	}
+	return nil
+}
+
+/*
+hasFamily looks a family up in GetColumnDescriptors output, whose names
+end with a colon
+*/
+func hasFamily(families map[string]*ColumnDescriptor, family string) bool {
+	if _, ok := families[family+":"]; ok {
+		return true
+	}
+	_, ok := families[family]
+	return ok
+}
+
+/*
+TimedMutations are the hbase1 mutations sharing one timestamp, 0 for
+server time
+*/
+type TimedMutations struct {
+	Timestamp int64
+	Mutations []*hbase1.Mutation
+}
+
+/*
+Mutations return the hbase1 mutations grouped by timestamp in the order
+the timestamps were first used. thrift1 takes one timestamp per call, each
+group goes to MutateRow or MutateRowTs.
+*/
+func (m *RowMutation) Mutations() ([]TimedMutations, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	var groups []TimedMutations
+	index := make(map[int64]int)
+	for _, op := range m.ops {
+		i, ok := index[op.timestamp]
+		if !ok {
+			i = len(groups)
+			index[op.timestamp] = i
+			groups = append(groups, TimedMutations{Timestamp: op.timestamp})
+		}
+
+		// "cf" deletes the family, "cf:" the column with an empty qualifier
+		column := op.family
+		if !op.isFamily {
+			column += ":" + op.qualifier
+		}
+		groups[i].Mutations = append(groups[i].Mutations, &hbase1.Mutation{
+			IsDelete:   op.isDelete,
+			Column:     hbase1.Text(column),
+			Value:      hbase1.Text(op.value),
+			WriteToWAL: m.wal,
+		})
+	}
+	return groups, nil
+}
+
+/*
+TRowMutations return the thrift2 mutations of the row, consecutive puts
+share one TPut
+*/
+func (m *RowMutation) TRowMutations() (*hbase2.TRowMutations, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	var durability *hbase2.TDurability
+	if !m.wal {
+		d := hbase2.TDurability_SKIP_WAL
+		durability = &d
+	}
+
+	rm := &hbase2.TRowMutations{Row: m.row}
+	var put *hbase2.TPut
+	for _, op := range m.ops {
+		var ts *int64
+		if op.timestamp > 0 {
+			t := op.timestamp
+			ts = &t
+		}
+
+		if op.isDelete {
+			put = nil
+			col := &hbase2.TColumn{Family: []byte(op.family), Timestamp: ts}
+			if !op.isFamily {
+				// set even when empty, a nil qualifier deletes the family
+				col.Qualifier = append([]byte{}, op.qualifier...)
+			}
+			rm.Mutations = append(rm.Mutations, &hbase2.TMutation{DeleteSingle: &hbase2.TDelete{
+				Row:        m.row,
+				Columns:    []*hbase2.TColumn{col},
+				DeleteType: hbase2.TDeleteType_DELETE_COLUMNS,
+				Durability: durability,
+			}})
+			continue
+		}
+
+		if put == nil {
+			put = &hbase2.TPut{Row: m.row, Durability: durability}
+			rm.Mutations = append(rm.Mutations, &hbase2.TMutation{Put: put})
+		}
+		put.ColumnValues = append(put.ColumnValues, &hbase2.TColumnValue{
+			Family:    []byte(op.family),
+			Qualifier: []byte(op.qualifier),
+			Value:     op.value,
+			Timestamp: ts,
+		})
+	}
+	return rm, nil
+}
+
+/*
+Families return the families used by the mutation, sorted
+*/
+func (m *RowMutation) Families() []string {
+	seen := make(map[string]bool)
+	var families []string
+	for _, op := range m.ops {
+		if !seen[op.family] {
+			seen[op.family] = true
+			families = append(families, op.family)
+		}
+	}
+	sort.Strings(families)
+	return families
+}
+
+/*
+ApplyRowMutation checks the mutation against the table's families and
+sends it, one MutateRow or MutateRowTs call per timestamp
+*/
+func (client *HClient) ApplyRowMutation(tableName string, m *RowMutation, attributes map[string]string) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	families, err := client.GetColumnDescriptors(tableName)
+	if err != nil {
+		return err
+	}
+	if err = m.Validate(families); err != nil {
+		return err
+	}
+	return client.sendRowMutation(tableName, m, attributes)
+}
+
+func (client *HClient) sendRowMutation(tableName string, m *RowMutation, attributes map[string]string) error {
+	groups, err := m.Mutations()
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		if g.Timestamp > 0 {
+			err = client.MutateRowTs(tableName, m.row, g.Mutations, g.Timestamp, attributes)
+		} else {
+			err = client.MutateRow(tableName, m.row, g.Mutations, attributes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"testing"
+)
+
+func TestDeleteColumnEmptyQualifier(t *testing.T) {
+	for _, tc := range []struct {
+		m      *RowMutation
+		column string
+		family bool
+	}{
+		{NewRowMutation([]byte("r")).DeleteColumn("cf:"), "cf:", false},
+		{NewRowMutation([]byte("r")).DeleteColumn("cf:q"), "cf:q", false},
+		{NewRowMutation([]byte("r")).DeleteFamily("cf"), "cf", true},
+	} {
+		groups, err := tc.m.Mutations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(groups[0].Mutations[0].Column); got != tc.column {
+			t.Errorf("thrift1 column %q, want %q", got, tc.column)
+		}
+
+		rm, err := tc.m.TRowMutations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		col := rm.Mutations[0].DeleteSingle.Columns[0]
+		if string(col.Family) != "cf" || col.IsSetQualifier() == tc.family {
+			t.Errorf("thrift2 column %q:%q (qualifier set %v) for %q", col.Family, col.Qualifier, col.IsSetQualifier(), tc.column)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+ErrMutatorClosed is returned by Mutate on a closed mutator
+*/
+var ErrMutatorClosed = errors.New("goh: mutator is closed")
+
+/*
+MutatorConfig sets when a BufferedMutator flushes and how fast it writes
+*/
+type MutatorConfig struct {
+	MaxRows       int           // flush when this many rows are buffered, 1000 by default
+	MaxBytes      int           // flush when the buffered keys and values reach this size, 4 MiB by default
+	RowsPerSec    float64       // throttle Mutate to this rate, 0 for no limit
+	FlushInterval time.Duration // flush in the background at least this often, 0 for never
+	Spool         *SpoolConfig  // spool the batches the cluster cannot take, nil for none
+}
+
+/*
+MutatorStats counts the writes of a BufferedMutator
+*/
+type MutatorStats struct {
+	Rows    int64 // rows written
+	Bytes   int64 // bytes of keys and values written
+	Batches int64 // MutateRows and MutateRowsTs calls
+	Errors  int64 // failed calls
+
+	Spooled        int64 // batches written to the spool
+	Replayed       int64 // spooled batches written to the table
+	Dropped        int64 // spooled batches the table refused
+	BacklogBatches int64 // batches in the spool
+	BacklogBytes   int64 // size of the spool
+}
+
+/*
+BufferedMutator batches row mutations of a table into MutateRows calls.
+thrift1 takes one timestamp per call, mutations are grouped by timestamp
+and each group is sent with MutateRows or MutateRowsTs. A failed flush
+keeps no data, the error is returned by the call which flushed or, for a
+background flush, by the next call.
+
+With a spool, a group which fails for the cluster being unreachable and
+every group flushed after it are appended to the spool instead, and
+replayed in order every RetryInterval; a spooled batch the table refuses
+is dropped and its error returned by the next call. The spool outlives
+the mutator, a new one on the same directory replays what is left. When
+the spool cannot be opened every Mutate returns the error, the mutator
+does not write without the durability asked for.
+*/
+type BufferedMutator struct {
+	client *HClient
+	table  string
+	conf   MutatorConfig
+	limit  *TokenBucket
+
+	flushMu sync.Mutex // serializes flushes, so writes to a row keep their order
+
+	mu     sync.Mutex
+	groups map[int64][]*hbase1.BatchMutation
+	rows   int
+	bytes  int
+	err    error
+	closed bool
+
+	spool    *spool // guarded by flushMu
+	spoolErr error  // the configured spool could not be opened
+
+	stats MutatorStats
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+/*
+NewBufferedMutator return a mutator writing to the table
+*/
+func NewBufferedMutator(client *HClient, tableName string, conf MutatorConfig) *BufferedMutator {
+	if conf.MaxRows <= 0 {
+		conf.MaxRows = 1000
+	}
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = 4 << 20
+	}
+
+	bm := &BufferedMutator{
+		client: client,
+		table:  tableName,
+		conf:   conf,
+		groups: make(map[int64][]*hbase1.BatchMutation),
+	}
+	if conf.RowsPerSec > 0 {
+		bm.limit = NewTokenBucket(conf.RowsPerSec, int(conf.RowsPerSec)+1)
+	}
+	if conf.Spool != nil {
+		if s, err := openSpool(*conf.Spool); err != nil {
+			bm.spoolErr = fmt.Errorf("goh: spool %s: %w", conf.Spool.Dir, err)
+		} else {
+			bm.spool = s
+			bm.setBacklog()
+		}
+	}
+
+	bm.stop = make(chan struct{})
+	if conf.FlushInterval > 0 {
+		bm.wg.Add(1)
+		go bm.flusher()
+	}
+	if bm.spool != nil {
+		bm.wg.Add(1)
+		go bm.replayer()
+	}
+	return bm
+}
+
+func (bm *BufferedMutator) setError(err error) {
+	bm.mu.Lock()
+	if bm.err == nil {
+		bm.err = err
+	}
+	bm.mu.Unlock()
+}
+
+func (bm *BufferedMutator) flusher() {
+	defer bm.wg.Done()
+
+	t := time.NewTicker(bm.conf.FlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-bm.stop:
+			return
+		case <-t.C:
+			if err := bm.Flush(); err != nil {
+				bm.setError(err)
+			}
+		}
+	}
+}
+
+/*
+Mutate buffers the mutation of a row, flushing when the buffer is full
+*/
+func (bm *BufferedMutator) Mutate(m *RowMutation) error {
+	if bm.spoolErr != nil {
+		return bm.spoolErr
+	}
+	groups, err := m.Mutations()
+	if err != nil {
+		return err
+	}
+	if bm.limit != nil {
+		bm.limit.Wait()
+	}
+
+	bm.mu.Lock()
+	if bm.closed {
+		bm.mu.Unlock()
+		return ErrMutatorClosed
+	}
+	if err = bm.err; err != nil {
+		bm.err = nil
+		bm.mu.Unlock()
+		return err
+	}
+
+	for _, g := range groups {
+		bm.groups[g.Timestamp] = append(bm.groups[g.Timestamp], NewBatchMutation(m.row, g.Mutations))
+		for _, mu := range g.Mutations {
+			bm.bytes += len(mu.Column) + len(mu.Value)
+		}
+	}
+	bm.rows++
+	bm.bytes += len(m.row)
+	full := bm.rows >= bm.conf.MaxRows || bm.bytes >= bm.conf.MaxBytes
+	bm.mu.Unlock()
+
+	if full {
+		return bm.Flush()
+	}
+	return nil
+}
+
+/*
+Flush sends the buffered mutations, or spools them
+*/
+func (bm *BufferedMutator) Flush() error {
+	bm.flushMu.Lock()
+	defer bm.flushMu.Unlock()
+
+	bm.mu.Lock()
+	groups, rows := bm.groups, bm.rows
+	bm.groups = make(map[int64][]*hbase1.BatchMutation)
+	bm.rows, bm.bytes = 0, 0
+	bm.mu.Unlock()
+
+	if rows == 0 {
+		return nil
+	}
+
+	timestamps := make([]int64, 0, len(groups))
+	for ts := range groups {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	for i, ts := range timestamps {
+		if bm.spool != nil && bm.spool.records > 0 {
+			// behind the backlog
+			return bm.spoolGroups(groups, timestamps[i:])
+		}
+		if err := bm.send(ts, groups[ts]); err != nil {
+			if bm.spool != nil && isTransient(err) {
+				return bm.spoolGroups(groups, timestamps[i:])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+send writes a group of rows with MutateRows, or MutateRowsTs when ts is
+set
+*/
+func (bm *BufferedMutator) send(ts int64, rows []*hbase1.BatchMutation) error {
+	var err error
+	if ts > 0 {
+		err = bm.client.MutateRowsTs(bm.table, rows, ts, nil)
+	} else {
+		err = bm.client.MutateRows(bm.table, rows, nil)
+	}
+	atomic.AddInt64(&bm.stats.Batches, 1)
+	if err != nil {
+		atomic.AddInt64(&bm.stats.Errors, 1)
+		return err
+	}
+
+	bytes := 0
+	for _, b := range rows {
+		bytes += len(b.Row)
+		for _, m := range b.Mutations {
+			bytes += len(m.Column) + len(m.Value)
+		}
+	}
+	atomic.AddInt64(&bm.stats.Rows, int64(len(rows)))
+	atomic.AddInt64(&bm.stats.Bytes, int64(bytes))
+	return nil
+}
+
+func (bm *BufferedMutator) spoolGroups(groups map[int64][]*hbase1.BatchMutation, timestamps []int64) error {
+	defer bm.setBacklog()
+	for _, ts := range timestamps {
+		if err := bm.spool.append(&spoolRecord{Timestamp: ts, Rows: groups[ts]}); err != nil {
+			return err
+		}
+		atomic.AddInt64(&bm.stats.Spooled, 1)
+	}
+	return nil
+}
+
+func (bm *BufferedMutator) setBacklog() {
+	atomic.StoreInt64(&bm.stats.BacklogBatches, bm.spool.records)
+	atomic.StoreInt64(&bm.stats.BacklogBytes, bm.spool.bytes)
+}
+
+func (bm *BufferedMutator) replayer() {
+	defer bm.wg.Done()
+
+	t := time.NewTicker(bm.spool.conf.RetryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-bm.stop:
+			return
+		case <-t.C:
+			if err := bm.replay(bm.stop); err != nil && !isTransient(err) {
+				bm.setError(err)
+			}
+		}
+	}
+}
+
+/*
+replay writes the spooled batches to the table in order, until the spool
+is empty, the cluster is unreachable or stop is closed. Flushes may run
+between two batches, they are spooled while the backlog is not empty.
+*/
+func (bm *BufferedMutator) replay(stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		done, err := bm.replayOne()
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+func (bm *BufferedMutator) replayOne() (bool, error) {
+	bm.flushMu.Lock()
+	defer bm.flushMu.Unlock()
+
+	rec, size, err := bm.spool.peek()
+	if err != nil || rec == nil {
+		return true, err
+	}
+
+	err = bm.send(rec.Timestamp, rec.Rows)
+	if isTransient(err) {
+		if isConnError(err) {
+			// the next attempt needs a new connection
+			bm.client.Close()
+			bm.client.Open()
+		}
+		return true, err
+	}
+	if err != nil {
+		atomic.AddInt64(&bm.stats.Dropped, 1)
+	} else {
+		atomic.AddInt64(&bm.stats.Replayed, 1)
+	}
+
+	if e := bm.spool.advance(size); e != nil {
+		return true, e
+	}
+	bm.setBacklog()
+	return false, err
+}
+
+/*
+Close stops the background work, flushes the buffer and tries to replay
+the spool once. Batches the cluster cannot take yet stay in the spool.
+*/
+func (bm *BufferedMutator) Close() error {
+	bm.mu.Lock()
+	if bm.closed {
+		bm.mu.Unlock()
+		return nil
+	}
+	bm.closed = true
+	err := bm.err
+	bm.err = nil
+	bm.mu.Unlock()
+
+	close(bm.stop)
+	bm.wg.Wait()
+
+	if e := bm.Flush(); err == nil {
+		err = e
+	}
+	if bm.spool != nil {
+		if e := bm.replay(nil); err == nil && !isTransient(e) {
+			err = e
+		}
+		if e := bm.spool.close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+/*
+Stats return the counters of the mutator
+*/
+func (bm *BufferedMutator) Stats() MutatorStats {
+	return MutatorStats{
+		Rows:    atomic.LoadInt64(&bm.stats.Rows),
+		Bytes:   atomic.LoadInt64(&bm.stats.Bytes),
+		Batches: atomic.LoadInt64(&bm.stats.Batches),
+		Errors:  atomic.LoadInt64(&bm.stats.Errors),
+
+		Spooled:        atomic.LoadInt64(&bm.stats.Spooled),
+		Replayed:       atomic.LoadInt64(&bm.stats.Replayed),
+		Dropped:        atomic.LoadInt64(&bm.stats.Dropped),
+		BacklogBatches: atomic.LoadInt64(&bm.stats.BacklogBatches),
+		BacklogBytes:   atomic.LoadInt64(&bm.stats.BacklogBytes),
+	}
+}
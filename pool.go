@@ -0,0 +1,134 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"sync"
+)
+
+/*
+ErrPoolClosed is returned by Get on a closed pool
+*/
+var ErrPoolClosed = errors.New("goh: pool is closed")
+
+/*
+Pool keeps open clients for reuse by concurrent callers, a client is used
+by one caller between Get and Put
+*/
+type Pool struct {
+	dial func() (*HClient, error)
+	idle chan *HClient
+
+	mu     sync.Mutex
+	closed bool
+}
+
+/*
+NewPool return a pool keeping up to size idle clients made by dial
+*/
+func NewPool(size int, dial func() (*HClient, error)) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		dial: dial,
+		idle: make(chan *HClient, size),
+	}
+}
+
+/*
+NewTCPPool return a pool of opened tcp clients
+*/
+func NewTCPPool(size int, ip string, port string, protocol int, framed bool) *Pool {
+	return NewPool(size, func() (*HClient, error) {
+		client, err := NewTCPClient(ip, port, protocol, framed)
+		if err != nil {
+			return nil, err
+		}
+		if err = client.Open(); err != nil {
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+/*
+Get return an idle client or dials a new one
+*/
+func (p *Pool) Get() (*HClient, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, ErrPoolClosed
+	}
+
+	select {
+	case client := <-p.idle:
+		return client, nil
+	default:
+		return p.dial()
+	}
+}
+
+/*
+Put gives back a client with the error of its last call, clients whose
+connection broke are closed instead of kept
+*/
+func (p *Pool) Put(client *HClient, err error) {
+	if client == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || isConnError(err) {
+		client.Close()
+		return
+	}
+
+	select {
+	case p.idle <- client:
+	default:
+		client.Close()
+	}
+}
+
+/*
+Close closes the idle clients, clients in use are closed when put back
+*/
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var err error
+	for {
+		select {
+		case client := <-p.idle:
+			if e := client.Close(); e != nil && err == nil {
+				err = e
+			}
+		default:
+			return err
+		}
+	}
+}
+
+/*
+isConnError reports whether err may have left the connection unusable, a
+transport, network or EOF error. Errors answered by the server, raised
+before sending or returned by the caller's code keep it.
+*/
+func isConnError(err error) bool {
+	return err != nil && isTransportError(err)
+}
@@ -0,0 +1,182 @@
+/*
+
+
+ */
+
+package query
+
+import (
+	"sort"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/filter"
+)
+
+/*
+Result holds the rows of a query
+*/
+type Result struct {
+	Columns []string // columns present in the rows, in order
+	Rows    []*goh.Row
+}
+
+/*
+Run compiles and runs a query, an EXPLAIN query return no rows
+*/
+func Run(client *goh.HClient, src string) (*Result, *Plan, error) {
+	q, err := Parse(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := q.Compile()
+	if err != nil {
+		return nil, nil, err
+	}
+	if q.Explain {
+		return &Result{}, plan, nil
+	}
+
+	res, err := plan.Run(client)
+	return res, plan, err
+}
+
+/*
+Run executes the plan
+*/
+func (plan *Plan) Run(client *goh.HClient) (*Result, error) {
+	var rows []*goh.Row
+	var err error
+
+	if plan.Keys != nil {
+		rows, err = plan.get(client)
+	} else {
+		rows, err = plan.scan(client)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Rows: rows}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for _, col := range row.Columns() {
+			if !seen[col] {
+				seen[col] = true
+				res.Columns = append(res.Columns, col)
+			}
+		}
+	}
+	sort.Strings(res.Columns)
+	return res, nil
+}
+
+func (plan *Plan) get(client *goh.HClient) ([]*goh.Row, error) {
+	if len(plan.Keys) == 0 {
+		return nil, nil
+	}
+
+	var rows []*goh.Row
+	var err error
+	switch {
+	case plan.Fetch == nil && plan.Timestamp > 0:
+		rows, err = client.ReadRowsTs(plan.Table, plan.Keys, plan.Timestamp, nil)
+	case plan.Fetch == nil:
+		rows, err = client.ReadRows(plan.Table, plan.Keys, nil)
+	case plan.Timestamp > 0:
+		rows, err = client.ReadRowsWithColumnsTs(plan.Table, plan.Keys, plan.Fetch, plan.Timestamp, nil)
+	default:
+		rows, err = client.ReadRowsWithColumns(plan.Table, plan.Keys, plan.Fetch, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// thrift1 gets take no filter string, column conditions run here
+	if plan.Filter != nil {
+		ev, err := filter.NewEvaluator(plan.Filter)
+		if err != nil {
+			return nil, err
+		}
+		kept := rows[:0]
+		for _, r := range rows {
+			if _, ok := ev.Filter(toRecord(r)); ok {
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+	}
+	rows = plan.project(rows)
+
+	if plan.Limit > 0 && int64(len(rows)) > plan.Limit {
+		rows = rows[:plan.Limit]
+	}
+	return rows, nil
+}
+
+func (plan *Plan) scan(client *goh.HClient) ([]*goh.Row, error) {
+	id, err := client.ScannerOpenWithScan(plan.Table, plan.Scan, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.ScannerClose(id)
+
+	var rows []*goh.Row
+	for {
+		n := int32(100)
+		if plan.Limit > 0 && plan.Limit-int64(len(rows)) < int64(n) {
+			n = int32(plan.Limit - int64(len(rows)))
+		}
+
+		batch, err := client.ScannerReadList(id, n)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, plan.project(batch)...)
+
+		if len(batch) == 0 || (plan.Limit > 0 && int64(len(rows)) >= plan.Limit) {
+			return rows, nil
+		}
+	}
+}
+
+/*
+project drops the cells of the columns read for the conditions only,
+and the rows left without cells
+*/
+func (plan *Plan) project(rows []*goh.Row) []*goh.Row {
+	if len(plan.Fetch) == len(plan.Columns) {
+		return rows
+	}
+
+	kept := rows[:0]
+	for _, row := range rows {
+		cells := row.Cells[:0]
+		for _, c := range row.Cells {
+			if covers(plan.Columns, c.Column()) {
+				cells = append(cells, c)
+			}
+		}
+		if len(cells) > 0 {
+			row.Cells = cells
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
+
+/*
+toRecord converts a row for the local filter evaluator
+*/
+func toRecord(row *goh.Row) filter.Record {
+	rec := filter.Record{Key: row.Key, Cells: make([]filter.Cell, len(row.Cells))}
+	for i, c := range row.Cells {
+		rec.Cells[i] = filter.Cell{
+			Family:    []byte(c.Family),
+			Qualifier: []byte(c.Qualifier),
+			Value:     c.Value,
+			Timestamp: c.Timestamp,
+		}
+	}
+	return rec
+}
@@ -0,0 +1,390 @@
+/*
+Package query runs a small SQL-like language against HBase tables:
+
+	SELECT cf:a, cf:b FROM users
+	WHERE ROWKEY BETWEEN 'u100' AND 'u200' AND cf:status = 'active'
+	LIMIT 10 AS OF 1500000000000
+
+Queries compile to a scanner opened with a TScan (start and stop rows,
+columns, timestamp and filter string) or to a GetRows call when the row
+keys are listed, EXPLAIN shows what would be sent.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+SyntaxError reports a malformed query
+*/
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+/*
+Error
+*/
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query: %s at offset %d", e.Msg, e.Pos)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokNumber
+	tokOp
+	tokComma
+	tokLParen
+	tokRParen
+	tokStar
+)
+
+type token struct {
+	kind tokenKind
+	pos  int
+	text string
+}
+
+func (t token) describe() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.text)
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for {
+		for i < len(src) && strings.IndexByte(" \t\r\n", src[i]) >= 0 {
+			i++
+		}
+		if i >= len(src) {
+			return append(toks, token{kind: tokEOF, pos: i}), nil
+		}
+
+		start := i
+		c := src[i]
+		switch {
+		case c == ',':
+			i++
+			toks = append(toks, token{tokComma, start, ","})
+		case c == '(':
+			i++
+			toks = append(toks, token{tokLParen, start, "("})
+		case c == ')':
+			i++
+			toks = append(toks, token{tokRParen, start, ")"})
+		case c == '*':
+			i++
+			toks = append(toks, token{tokStar, start, "*"})
+
+		case c == '\'' || c == '`':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, &SyntaxError{Pos: start, Msg: "unterminated quoted string"}
+				}
+				if src[i] == c {
+					if i+1 < len(src) && src[i+1] == c {
+						sb.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			kind := tokString
+			if c == '`' {
+				kind = tokWord
+			}
+			toks = append(toks, token{kind, start, sb.String()})
+
+		case c == '<' || c == '>' || c == '=' || c == '!':
+			i++
+			if i < len(src) && (src[i] == '=' || (c == '<' && src[i] == '>')) {
+				i++
+			}
+			op := src[start:i]
+			if op == "!" {
+				return nil, &SyntaxError{Pos: start, Msg: "invalid operator \"!\""}
+			}
+			if op == "<>" {
+				op = "!="
+			}
+			toks = append(toks, token{tokOp, start, op})
+
+		case c >= '0' && c <= '9' || c == '-':
+			i++
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+			toks = append(toks, token{tokNumber, start, src[start:i]})
+
+		case isWordChar(c):
+			for i < len(src) && (isWordChar(src[i]) || src[i] == ':') {
+				i++
+			}
+			toks = append(toks, token{tokWord, start, src[start:i]})
+
+		default:
+			return nil, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c|0x20 >= 'a' && c|0x20 <= 'z') || (c >= '0' && c <= '9')
+}
+
+/*
+Cond is one condition of the WHERE clause
+*/
+type Cond struct {
+	Column string   // ROWKEY or family:qualifier
+	Op     string   // =, !=, <, <=, >, >=, BETWEEN, PREFIX, IN, CONTAINS, MATCHES
+	Values []string // operands
+}
+
+/*
+Query is a parsed query
+*/
+type Query struct {
+	Explain   bool
+	Columns   []string // nil selects all columns
+	Table     string
+	Where     []Cond
+	Limit     int64
+	Timestamp int64
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) keyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokWord {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.keyword(word) {
+		t := p.peek()
+		return &SyntaxError{Pos: t.pos, Msg: "expected " + word + ", found " + t.describe()}
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, &SyntaxError{Pos: t.pos, Msg: "expected " + what + ", found " + t.describe()}
+	}
+	return t, nil
+}
+
+/*
+Parse parses a query
+*/
+func Parse(src string) (*Query, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	q := &Query{}
+
+	if p.keyword("EXPLAIN") {
+		p.next()
+		q.Explain = true
+	}
+	if err = p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokStar {
+		p.next()
+	} else {
+		for {
+			t, err := p.expect(tokWord, "column")
+			if err != nil {
+				return nil, err
+			}
+			q.Columns = append(q.Columns, t.text)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err = p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	t, err := p.expect(tokWord, "table name")
+	if err != nil {
+		return nil, err
+	}
+	q.Table = t.text
+
+	if p.keyword("WHERE") {
+		p.next()
+		for {
+			c, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			q.Where = append(q.Where, c)
+			if !p.keyword("AND") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		p.next()
+		if q.Limit, err = p.number(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("AS") {
+		p.next()
+		if err = p.expectKeyword("OF"); err != nil {
+			return nil, err
+		}
+		if q.Timestamp, err = p.number(); err != nil {
+			return nil, err
+		}
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &SyntaxError{Pos: t.pos, Msg: "unexpected " + t.describe()}
+	}
+	return q, nil
+}
+
+func (p *parser) number() (int64, error) {
+	t, err := p.expect(tokNumber, "number")
+	if err != nil {
+		return 0, err
+	}
+	n, e := strconv.ParseInt(t.text, 10, 64)
+	if e != nil {
+		return 0, &SyntaxError{Pos: t.pos, Msg: "invalid number " + strconv.Quote(t.text)}
+	}
+	return n, nil
+}
+
+func (p *parser) str() (string, error) {
+	t, err := p.expect(tokString, "quoted string")
+	return t.text, err
+}
+
+func (p *parser) parseCond() (Cond, error) {
+	t, err := p.expect(tokWord, "ROWKEY or column")
+	if err != nil {
+		return Cond{}, err
+	}
+
+	c := Cond{Column: t.text}
+	if strings.EqualFold(c.Column, "ROWKEY") {
+		c.Column = "ROWKEY"
+	} else if !strings.Contains(c.Column, ":") {
+		return c, &SyntaxError{Pos: t.pos, Msg: "column " + strconv.Quote(c.Column) + " is not family:qualifier"}
+	}
+
+	switch {
+	case p.peek().kind == tokOp:
+		c.Op = p.next().text
+		v, err := p.str()
+		if err != nil {
+			return c, err
+		}
+		c.Values = []string{v}
+
+	case p.keyword("BETWEEN"):
+		p.next()
+		c.Op = "BETWEEN"
+		lo, err := p.str()
+		if err != nil {
+			return c, err
+		}
+		if err = p.expectKeyword("AND"); err != nil {
+			return c, err
+		}
+		hi, err := p.str()
+		if err != nil {
+			return c, err
+		}
+		c.Values = []string{lo, hi}
+
+	case p.keyword("PREFIX", "CONTAINS", "MATCHES"):
+		c.Op = strings.ToUpper(p.next().text)
+		v, err := p.str()
+		if err != nil {
+			return c, err
+		}
+		c.Values = []string{v}
+
+	case p.keyword("IN"):
+		p.next()
+		c.Op = "IN"
+		if _, err := p.expect(tokLParen, "\"(\""); err != nil {
+			return c, err
+		}
+		for {
+			v, err := p.str()
+			if err != nil {
+				return c, err
+			}
+			c.Values = append(c.Values, v)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokRParen, "\")\""); err != nil {
+			return c, err
+		}
+
+	default:
+		t := p.peek()
+		return c, &SyntaxError{Pos: t.pos, Msg: "expected operator, found " + t.describe()}
+	}
+	return c, nil
+}
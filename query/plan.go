@@ -0,0 +1,317 @@
+/*
+
+
+ */
+
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/filter"
+)
+
+/*
+Plan is a compiled query, either a scan or a multi-row get
+*/
+type Plan struct {
+	Table     string
+	Columns   []string      // selected columns, nil for all
+	Fetch     []string      // columns read: Columns and those of the conditions, nil for all
+	Keys      [][]byte      // rows to get, nil for a scan
+	Scan      *goh.TScan    // scan parameters, nil for a get
+	Filter    filter.Filter // column conditions, nil when there are none
+	Limit     int64
+	Timestamp int64
+}
+
+/*
+Compile parses and compiles a query
+*/
+func Compile(src string) (*Plan, error) {
+	q, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return q.Compile()
+}
+
+/*
+Compile turns the row key conditions into a key range or key list and
+the column conditions into a filter
+*/
+func (q *Query) Compile() (*Plan, error) {
+	plan := &Plan{
+		Table:     q.Table,
+		Columns:   q.Columns,
+		Limit:     q.Limit,
+		Timestamp: q.Timestamp,
+	}
+
+	// the filters need the columns of the conditions, they are dropped
+	// from the rows returned
+	if q.Columns != nil {
+		plan.Fetch = append([]string{}, q.Columns...)
+		for _, c := range q.Where {
+			if c.Column != "ROWKEY" && !covers(plan.Fetch, c.Column) {
+				plan.Fetch = append(plan.Fetch, c.Column)
+			}
+		}
+	}
+
+	var start, stop []byte
+	var keys [][]byte
+	haveKeys := false
+	var filters []filter.Filter
+
+	for _, c := range q.Where {
+		if c.Column != "ROWKEY" {
+			f, err := columnFilter(c)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+			continue
+		}
+
+		v := []byte(c.Values[0])
+		switch c.Op {
+		case "=", "IN":
+			set := make([][]byte, len(c.Values))
+			for i, s := range c.Values {
+				set[i] = []byte(s)
+			}
+			if haveKeys {
+				set = intersect(keys, set)
+			}
+			keys, haveKeys = set, true
+		case ">=":
+			start = maxKey(start, v)
+		case ">":
+			start = maxKey(start, append(append([]byte{}, v...), 0))
+		case "<":
+			stop = minKey(stop, v)
+		case "<=":
+			stop = minKey(stop, append(append([]byte{}, v...), 0))
+		case "BETWEEN":
+			start = maxKey(start, v)
+			stop = minKey(stop, append([]byte(c.Values[1]), 0))
+		case "PREFIX":
+			start = maxKey(start, v)
+			if end := prefixEnd(v); end != nil {
+				stop = minKey(stop, end)
+			}
+		case "!=":
+			filters = append(filters, filter.Row(filter.NotEqual, filter.Binary(v)))
+		default:
+			return nil, fmt.Errorf("query: operator %s is not supported on ROWKEY", c.Op)
+		}
+	}
+
+	if len(filters) == 1 {
+		plan.Filter = filters[0]
+	} else if len(filters) > 1 {
+		plan.Filter = filter.And(filters...)
+	}
+
+	if haveKeys {
+		for _, k := range keys {
+			if bytes.Compare(k, start) >= 0 && (stop == nil || bytes.Compare(k, stop) < 0) {
+				plan.Keys = append(plan.Keys, k)
+			}
+		}
+		if plan.Keys == nil {
+			plan.Keys = [][]byte{}
+		}
+		return plan, nil
+	}
+
+	plan.Scan = &goh.TScan{
+		StartRow:  start,
+		StopRow:   stop,
+		Columns:   plan.Fetch,
+		Timestamp: q.Timestamp,
+	}
+
+	if q.Limit > 0 {
+		plan.Scan.Caching = int32(minInt64(q.Limit, 1000))
+	}
+
+	// a page filter also counts rows dropped by later filters, it is
+	// only safe on its own
+	switch {
+	case plan.Filter != nil:
+		plan.Scan.SetFilter(plan.Filter)
+	case q.Limit > 0:
+		plan.Scan.SetFilter(filter.Page(q.Limit))
+	}
+	return plan, nil
+}
+
+/*
+columnFilter turns a family:qualifier condition into a filter which
+drops the rows lacking the column
+*/
+func columnFilter(c Cond) (filter.Filter, error) {
+	i := strings.IndexByte(c.Column, ':')
+	family, qualifier := c.Column[:i], c.Column[i+1:]
+
+	scv := func(op filter.CompareOp, cmp filter.Comparator) filter.Filter {
+		return filter.SingleColumnValue(family, qualifier, op, cmp, true, true)
+	}
+
+	switch c.Op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return scv(filter.CompareOp(c.Op), filter.Binary([]byte(c.Values[0]))), nil
+	case "BETWEEN":
+		return filter.And(
+			scv(filter.GreaterOrEqual, filter.Binary([]byte(c.Values[0]))),
+			scv(filter.LessOrEqual, filter.Binary([]byte(c.Values[1]))),
+		), nil
+	case "PREFIX":
+		return scv(filter.Equal, filter.BinaryPrefix([]byte(c.Values[0]))), nil
+	case "CONTAINS":
+		return scv(filter.Equal, filter.Substring(c.Values[0])), nil
+	case "MATCHES":
+		f := scv(filter.Equal, filter.Regex(c.Values[0]))
+		return f, filter.Validate(f)
+	case "IN":
+		fs := make([]filter.Filter, len(c.Values))
+		for i, v := range c.Values {
+			fs[i] = scv(filter.Equal, filter.Binary([]byte(v)))
+		}
+		return filter.Or(fs...), nil
+	}
+	return nil, fmt.Errorf("query: operator %s is not supported on columns", c.Op)
+}
+
+/*
+Explain describes the calls the plan makes
+*/
+func (plan *Plan) Explain() string {
+	var sb strings.Builder
+
+	if plan.Keys != nil {
+		call := "GetRows"
+		if plan.Fetch != nil {
+			call += "WithColumns"
+		}
+		if plan.Timestamp > 0 {
+			call += "Ts"
+		}
+		sb.WriteString(call + "\n")
+		fmt.Fprintf(&sb, "  table:     %s\n", plan.Table)
+		fmt.Fprintf(&sb, "  rows:      %s\n", quoteKeys(plan.Keys))
+		if plan.Fetch != nil {
+			fmt.Fprintf(&sb, "  columns:   %s\n", strings.Join(plan.Fetch, ", "))
+		}
+		if plan.Timestamp > 0 {
+			fmt.Fprintf(&sb, "  timestamp: %d\n", plan.Timestamp)
+		}
+		if plan.Filter != nil {
+			fmt.Fprintf(&sb, "  client-side filter: %s\n", plan.Filter)
+		}
+	} else {
+		s := plan.Scan
+		sb.WriteString("ScannerOpenWithScan\n")
+		fmt.Fprintf(&sb, "  table:        %s\n", plan.Table)
+		fmt.Fprintf(&sb, "  startRow:     %s\n", strconv.Quote(string(s.StartRow)))
+		fmt.Fprintf(&sb, "  stopRow:      %s\n", strconv.Quote(string(s.StopRow)))
+		if s.Columns != nil {
+			fmt.Fprintf(&sb, "  columns:      %s\n", strings.Join(s.Columns, ", "))
+		}
+		if s.Timestamp > 0 {
+			fmt.Fprintf(&sb, "  timestamp:    %d\n", s.Timestamp)
+		}
+		if s.Caching > 0 {
+			fmt.Fprintf(&sb, "  caching:      %d\n", s.Caching)
+		}
+		if s.FilterString != "" {
+			fmt.Fprintf(&sb, "  filterString: %s\n", s.FilterString)
+		}
+	}
+	if plan.Limit > 0 {
+		fmt.Fprintf(&sb, "  limit:        %d (client-side)\n", plan.Limit)
+	}
+	return sb.String()
+}
+
+/*
+covers reports whether the column list holds the column or its family
+*/
+func covers(columns []string, column string) bool {
+	family, _ := goh.SplitColumn(column)
+	for _, c := range columns {
+		if c == column || c == family {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteKeys(keys [][]byte) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = strconv.Quote(string(k))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func intersect(a, b [][]byte) [][]byte {
+	in := make(map[string]bool, len(a))
+	for _, k := range a {
+		in[string(k)] = true
+	}
+	var out [][]byte
+	for _, k := range b {
+		if in[string(k)] {
+			out = append(out, k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i], out[j]) < 0 })
+	return out
+}
+
+func maxKey(a, b []byte) []byte {
+	if bytes.Compare(a, b) >= 0 {
+		return a
+	}
+	return b
+}
+
+/*
+minKey return the lower stop row, nil means no stop row
+*/
+func minKey(a, b []byte) []byte {
+	if a == nil || (b != nil && bytes.Compare(b, a) < 0) {
+		return b
+	}
+	return a
+}
+
+/*
+prefixEnd return the first key after every key starting with prefix, nil
+when there is none
+*/
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+/*
+
+
+ */
+
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chenjingping/goh"
+	"github.com/chenjingping/goh/filter"
+)
+
+func TestConditionColumnsFetched(t *testing.T) {
+	for _, src := range []string{
+		"SELECT cf:a FROM t WHERE cf:status = 'active'",
+		"SELECT cf:a FROM t WHERE ROWKEY = 'r1' AND cf:status = 'active'",
+	} {
+		plan, err := Compile(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+
+		want := []string{"cf:a", "cf:status"}
+		if !reflect.DeepEqual(plan.Fetch, want) {
+			t.Errorf("%s: fetch %v, want %v", src, plan.Fetch, want)
+		}
+		if plan.Scan != nil && !reflect.DeepEqual(plan.Scan.Columns, want) {
+			t.Errorf("%s: scan columns %v, want %v", src, plan.Scan.Columns, want)
+		}
+
+		// a matching row read with the fetched columns passes the filter
+		row := &goh.Row{Key: []byte("r1"), Cells: []*goh.Cell{
+			{Family: "cf", Qualifier: "a", Value: []byte("1")},
+			{Family: "cf", Qualifier: "status", Value: []byte("active")},
+		}}
+		ev, err := filter.NewEvaluator(plan.Filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ev.Filter(toRecord(row)); !ok {
+			t.Errorf("%s: matching row rejected", src)
+		}
+
+		rows := plan.project([]*goh.Row{row})
+		if len(rows) != 1 || len(rows[0].Cells) != 1 || rows[0].Cells[0].Column() != "cf:a" {
+			t.Errorf("%s: projected %v, want cf:a only", src, rows)
+		}
+	}
+}
+
+func TestSelectedFamilyCoversCondition(t *testing.T) {
+	plan, err := Compile("SELECT cf FROM t WHERE cf:status = 'active'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cf"}; !reflect.DeepEqual(plan.Fetch, want) {
+		t.Errorf("fetch %v, want %v", plan.Fetch, want)
+	}
+
+	plan, err = Compile("SELECT * FROM t WHERE cf:status = 'active'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Fetch != nil {
+		t.Errorf("fetch %v, want all columns", plan.Fetch)
+	}
+}
@@ -0,0 +1,262 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+TokenBucket is a token bucket rate limiter
+*/
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+/*
+NewTokenBucket return a full bucket refilled with rate tokens per second
+holding at most burst tokens
+*/
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+/*
+Allow takes a token, it return false when the bucket is empty
+*/
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+/*
+Wait takes a token, sleeping until the bucket holds one
+*/
+func (b *TokenBucket) Wait() {
+	for {
+		b.mu.Lock()
+		b.refill()
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return
+		}
+		d := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		time.Sleep(d)
+	}
+}
+
+/*
+ready reports whether the bucket holds a token, without taking it
+*/
+func (b *TokenBucket) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.tokens >= 1
+}
+
+/*
+take takes a token whether or not the bucket holds one
+*/
+func (b *TokenBucket) take() {
+	b.mu.Lock()
+	b.refill()
+	b.tokens--
+	b.mu.Unlock()
+}
+
+func (b *TokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+/*
+RateLimitStats counts the calls seen by the rate limits of a table
+*/
+type RateLimitStats struct {
+	ReadsAllowed   int64
+	ReadsRejected  int64
+	WritesAllowed  int64
+	WritesRejected int64
+}
+
+/*
+tableLimit holds the read and write buckets of a table, a nil bucket
+means unlimited
+*/
+type tableLimit struct {
+	read  *TokenBucket
+	write *TokenBucket
+	stats RateLimitStats
+}
+
+/*
+ready reports whether a call of kind may be sent, counting a rejection
+when it may not
+*/
+func (l *tableLimit) ready(kind int) bool {
+	switch kind {
+	case opRead:
+		if l.read != nil && !l.read.ready() {
+			atomic.AddInt64(&l.stats.ReadsRejected, 1)
+			return false
+		}
+	case opWrite:
+		if l.write != nil && !l.write.ready() {
+			atomic.AddInt64(&l.stats.WritesRejected, 1)
+			return false
+		}
+	}
+	return true
+}
+
+/*
+take spends the token of an admitted call
+*/
+func (l *tableLimit) take(kind int) {
+	switch kind {
+	case opRead:
+		if l.read != nil {
+			l.read.take()
+		}
+		atomic.AddInt64(&l.stats.ReadsAllowed, 1)
+	case opWrite:
+		if l.write != nil {
+			l.write.take()
+		}
+		atomic.AddInt64(&l.stats.WritesAllowed, 1)
+	}
+}
+
+func (l *tableLimit) snapshot() RateLimitStats {
+	return RateLimitStats{
+		ReadsAllowed:   atomic.LoadInt64(&l.stats.ReadsAllowed),
+		ReadsRejected:  atomic.LoadInt64(&l.stats.ReadsRejected),
+		WritesAllowed:  atomic.LoadInt64(&l.stats.WritesAllowed),
+		WritesRejected: atomic.LoadInt64(&l.stats.WritesRejected),
+	}
+}
+
+/*
+ClientStats is a snapshot of the breaker and rate limits of a client
+*/
+type ClientStats struct {
+	Addr    string                    // endpoint of the client
+	Breaker *BreakerStats             // nil without a breaker
+	Tables  map[string]RateLimitStats // per rate limited table
+}
+
+/*
+SetCircuitBreaker guards the endpoint of the client with b, nil removes it
+*/
+func (client *HClient) SetCircuitBreaker(b *CircuitBreaker) {
+	client.mu.Lock()
+	client.breaker = b
+	client.mu.Unlock()
+}
+
+/*
+SetRateLimit limits the reads and writes per second sent for the table,
+a rate of 0 leaves that direction unlimited
+*/
+func (client *HClient) SetRateLimit(tableName string, readsPerSec, writesPerSec float64, burst int) {
+	l := &tableLimit{}
+	if readsPerSec > 0 {
+		l.read = NewTokenBucket(readsPerSec, burst)
+	}
+	if writesPerSec > 0 {
+		l.write = NewTokenBucket(writesPerSec, burst)
+	}
+
+	client.mu.Lock()
+	if client.limits == nil {
+		client.limits = make(map[string]*tableLimit)
+	}
+	client.limits[tableName] = l
+	client.mu.Unlock()
+}
+
+/*
+Stats return the state of the breaker and rate limits
+*/
+func (client *HClient) Stats() ClientStats {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	stats := ClientStats{
+		Addr:   client.addr,
+		Tables: make(map[string]RateLimitStats, len(client.limits)),
+	}
+	if client.breaker != nil {
+		b := client.breaker.Stats()
+		stats.Breaker = &b
+	}
+	for name, l := range client.limits {
+		stats.Tables[name] = l.snapshot()
+	}
+	return stats
+}
+
+/*
+admit checks the rate limits of the tables and the breaker before a call,
+it return the breaker to report the outcome to. Tokens are taken only
+once every check passed, a rejected call spends none.
+*/
+func (client *HClient) admit(tables []string, kind int) (*CircuitBreaker, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for _, name := range tables {
+		if l, ok := client.limits[name]; ok && !l.ready(kind) {
+			return nil, fmt.Errorf("%w: table %s", ErrRateLimited, name)
+		}
+	}
+
+	breaker := client.breaker
+	if breaker != nil {
+		if err := breaker.Allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range tables {
+		if l, ok := client.limits[name]; ok {
+			l.take(kind)
+		}
+	}
+	return breaker, nil
+}
@@ -0,0 +1,367 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+endpoint is a thrift connection guarded for use by one caller at a time
+*/
+type endpoint struct {
+	mu     sync.Mutex
+	client *HClient
+}
+
+/*
+Router sends row-addressed calls to the thrift gateway running on the
+region server that hosts the row, avoiding the extra hop through a
+central gateway. Region locations are cached per table and refreshed when
+the server reports that a region moved.
+*/
+type Router struct {
+	meta     *endpoint
+	port     string
+	protocol int
+	framed   bool
+	breaker  *BreakerConfig
+
+	mu        sync.Mutex
+	regions   map[string][]*TRegionInfo
+	endpoints map[string]*endpoint
+}
+
+/*
+NewRouter return a router which looks up regions through client and
+connects to the thrift gateway listening on port of every region server.
+Rows whose gateway cannot be reached are served by client.
+*/
+func NewRouter(client *HClient, port string, protocol int, framed bool) *Router {
+	return &Router{
+		meta:      &endpoint{client: client},
+		port:      port,
+		protocol:  protocol,
+		framed:    framed,
+		regions:   make(map[string][]*TRegionInfo),
+		endpoints: make(map[string]*endpoint),
+	}
+}
+
+/*
+SetBreakerConfig gives every gateway connection opened from now on its
+own circuit breaker
+*/
+func (r *Router) SetBreakerConfig(cfg BreakerConfig) {
+	r.mu.Lock()
+	r.breaker = &cfg
+	r.mu.Unlock()
+}
+
+/*
+Stats return the stats of every gateway connection by address
+*/
+func (r *Router) Stats() map[string]ClientStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make(map[string]ClientStats, len(r.endpoints))
+	for addr, ep := range r.endpoints {
+		stats[addr] = ep.client.Stats()
+	}
+	return stats
+}
+
+/*
+Close closes all connections opened by the router, the lookup client is
+left open.
+*/
+func (r *Router) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for addr, ep := range r.endpoints {
+		ep.mu.Lock()
+		if e := ep.client.Close(); e != nil && err == nil {
+			err = e
+		}
+		ep.mu.Unlock()
+		delete(r.endpoints, addr)
+	}
+	return err
+}
+
+/*
+Invalidate drops the cached regions of the table
+*/
+func (r *Router) Invalidate(tableName string) {
+	r.mu.Lock()
+	delete(r.regions, tableName)
+	r.mu.Unlock()
+}
+
+/*
+Locate return the cached region serving the row
+*/
+func (r *Router) Locate(tableName string, row []byte) (*TRegionInfo, error) {
+	r.mu.Lock()
+	regions, ok := r.regions[tableName]
+	r.mu.Unlock()
+
+	if !ok {
+		var err error
+		r.meta.mu.Lock()
+		regions, err = r.meta.client.GetTableRegions(tableName)
+		r.meta.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(regions, func(i, j int) bool {
+			return regions[i].StartKey < regions[j].StartKey
+		})
+
+		r.mu.Lock()
+		r.regions[tableName] = regions
+		r.mu.Unlock()
+	}
+
+	return findRegion(regions, row), nil
+}
+
+/*
+findRegion return the region of the sorted list whose key range holds row
+*/
+func findRegion(regions []*TRegionInfo, row []byte) *TRegionInfo {
+	key := string(row)
+	i := sort.Search(len(regions), func(i int) bool {
+		return regions[i].StartKey > key
+	})
+	if i == 0 {
+		return nil
+	}
+
+	region := regions[i-1]
+	if region.EndKey != "" && key >= region.EndKey {
+		return nil
+	}
+	return region
+}
+
+/*
+endpointFor return the connection of the gateway co-located with the
+region, falling back to the lookup client
+*/
+func (r *Router) endpointFor(region *TRegionInfo) *endpoint {
+	if region == nil || region.ServerName == "" {
+		return r.meta
+	}
+
+	addr := net.JoinHostPort(region.ServerName, r.port)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ep, ok := r.endpoints[addr]; ok {
+		return ep
+	}
+
+	client, err := NewTCPClient(region.ServerName, r.port, r.protocol, r.framed)
+	if err != nil {
+		return r.meta
+	}
+	if err = client.Open(); err != nil {
+		return r.meta
+	}
+
+	if r.breaker != nil {
+		client.SetCircuitBreaker(NewCircuitBreaker(*r.breaker))
+	}
+
+	ep := &endpoint{client: client}
+	r.endpoints[addr] = ep
+	return ep
+}
+
+/*
+do runs fn against the gateway serving the row, refreshing the region
+cache and retrying once when the region has moved
+*/
+func (r *Router) do(tableName string, row []byte, fn func(client *HClient) error) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var region *TRegionInfo
+		if region, err = r.Locate(tableName, row); err != nil {
+			return err
+		}
+
+		ep := r.endpointFor(region)
+		ep.mu.Lock()
+		err = fn(ep.client)
+		ep.mu.Unlock()
+
+		if !IsRegionMoved(err) {
+			return err
+		}
+		r.Invalidate(tableName)
+	}
+	return err
+}
+
+/*
+IsRegionMoved reports whether err means the region is no longer served
+where the cached location says it is
+*/
+func IsRegionMoved(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, name := range []string{
+		"NotServingRegionException",
+		"RegionMovedException",
+		"RegionOpeningException",
+		"RegionServerStoppedException",
+	} {
+		if strings.Contains(msg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Get is HClient.Get sent to the gateway serving the row
+*/
+func (r *Router) Get(tableName string, row []byte, column string, attributes map[string]string) (data []*hbase1.TCell, err error) {
+	err = r.do(tableName, row, func(client *HClient) (e error) {
+		data, e = client.Get(tableName, row, column, attributes)
+		return
+	})
+	return
+}
+
+/*
+GetRow is HClient.GetRow sent to the gateway serving the row
+*/
+func (r *Router) GetRow(tableName string, row []byte, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
+	err = r.do(tableName, row, func(client *HClient) (e error) {
+		data, e = client.GetRow(tableName, row, attributes)
+		return
+	})
+	return
+}
+
+/*
+GetRowWithColumns is HClient.GetRowWithColumns sent to the gateway serving the row
+*/
+func (r *Router) GetRowWithColumns(tableName string, row []byte, columns []string, attributes map[string]string) (data []*hbase1.TRowResult_, err error) {
+	err = r.do(tableName, row, func(client *HClient) (e error) {
+		data, e = client.GetRowWithColumns(tableName, row, columns, attributes)
+		return
+	})
+	return
+}
+
+/*
+MutateRow is HClient.MutateRow sent to the gateway serving the row
+*/
+func (r *Router) MutateRow(tableName string, row []byte, mutations []*hbase1.Mutation, attributes map[string]string) error {
+	return r.do(tableName, row, func(client *HClient) error {
+		return client.MutateRow(tableName, row, mutations, attributes)
+	})
+}
+
+/*
+Scan opens one scanner per region overlapping the scan range, each on the
+gateway co-located with the region, and passes the rows to fn in key
+order. Scanning stops early when fn returns an error.
+*/
+func (r *Router) Scan(tableName string, scan *TScan, attributes map[string]string, fn func(row *hbase1.TRowResult_) error) error {
+	if scan == nil {
+		scan = &TScan{}
+	}
+
+	start := scan.StartRow
+	for {
+		region, err := r.Locate(tableName, start)
+		if err != nil {
+			return err
+		}
+
+		sub := *scan
+		sub.StartRow = start
+		if region != nil && region.EndKey != "" {
+			end := []byte(region.EndKey)
+			if len(scan.StopRow) == 0 || bytes.Compare(end, scan.StopRow) < 0 {
+				sub.StopRow = end
+			}
+		}
+
+		// a retry after a region move resumes behind the last delivered row
+		var last []byte
+		err = r.do(tableName, start, func(client *HClient) error {
+			if last != nil {
+				sub.StartRow = append(append([]byte{}, last...), 0)
+			}
+			return scanAll(client, tableName, &sub, attributes, func(row *hbase1.TRowResult_) error {
+				last = row.Row
+				return fn(row)
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		if region == nil || region.EndKey == "" {
+			return nil
+		}
+		if len(scan.StopRow) > 0 && bytes.Compare([]byte(region.EndKey), scan.StopRow) >= 0 {
+			return nil
+		}
+		start = []byte(region.EndKey)
+	}
+}
+
+/*
+scanAll drains a scanner opened with scan through fn
+*/
+func scanAll(client *HClient, tableName string, scan *TScan, attributes map[string]string, fn func(row *hbase1.TRowResult_) error) error {
+	id, err := client.ScannerOpenWithScan(tableName, scan, attributes)
+	if err != nil {
+		return err
+	}
+	defer client.ScannerClose(id)
+
+	batch := scan.Caching
+	if batch <= 0 {
+		batch = 100
+	}
+
+	for {
+		rows, err := client.ScannerGetList(id, batch)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		for _, row := range rows {
+			if err = fn(row); err != nil {
+				return err
+			}
+		}
+	}
+}
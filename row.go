@@ -0,0 +1,312 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+Cell is one version of a column value
+*/
+type Cell struct {
+	Family    string
+	Qualifier string
+	Value     []byte
+	Timestamp int64
+}
+
+/*
+Column return family:qualifier
+*/
+func (c *Cell) Column() string {
+	return c.Family + ":" + c.Qualifier
+}
+
+/*
+Row holds the cells of a row ordered by family and qualifier, versions of
+a column newest first
+*/
+type Row struct {
+	Key   []byte
+	Cells []*Cell
+}
+
+/*
+SplitColumn splits family:qualifier, a column without colon is a family
+*/
+func SplitColumn(column string) (family, qualifier string) {
+	if i := strings.IndexByte(column, ':'); i >= 0 {
+		return column[:i], column[i+1:]
+	}
+	return column, ""
+}
+
+/*
+Cell return the newest version of the column, nil when absent
+*/
+func (r *Row) Cell(family, qualifier string) *Cell {
+	for _, c := range r.Cells {
+		if c.Family == family && c.Qualifier == qualifier {
+			return c
+		}
+	}
+	return nil
+}
+
+/*
+Value return the newest value of the column, nil when absent
+*/
+func (r *Row) Value(family, qualifier string) []byte {
+	if c := r.Cell(family, qualifier); c != nil {
+		return c.Value
+	}
+	return nil
+}
+
+/*
+Versions return every version of the column, newest first
+*/
+func (r *Row) Versions(family, qualifier string) []*Cell {
+	var cells []*Cell
+	for _, c := range r.Cells {
+		if c.Family == family && c.Qualifier == qualifier {
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+/*
+Family return the newest version of every column of the family
+*/
+func (r *Row) Family(family string) []*Cell {
+	var cells []*Cell
+	for _, c := range r.Cells {
+		if c.Family == family && (len(cells) == 0 || cells[len(cells)-1].Qualifier != c.Qualifier) {
+			cells = append(cells, c)
+		}
+	}
+	return cells
+}
+
+/*
+Columns return the family:qualifier names of the row in order
+*/
+func (r *Row) Columns() []string {
+	var cols []string
+	for i, c := range r.Cells {
+		if i > 0 && r.Cells[i-1].Family == c.Family && r.Cells[i-1].Qualifier == c.Qualifier {
+			continue
+		}
+		cols = append(cols, c.Column())
+	}
+	return cols
+}
+
+/*
+Map return the newest value of every column keyed by family:qualifier
+*/
+func (r *Row) Map() map[string][]byte {
+	m := make(map[string][]byte, len(r.Cells))
+	for i := len(r.Cells) - 1; i >= 0; i-- {
+		m[r.Cells[i].Column()] = r.Cells[i].Value
+	}
+	return m
+}
+
+/*
+sortCells orders cells by family, qualifier and newest timestamp
+*/
+func sortCells(cells []*Cell) {
+	sort.SliceStable(cells, func(i, j int) bool {
+		a, b := cells[i], cells[j]
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		if a.Qualifier != b.Qualifier {
+			return a.Qualifier < b.Qualifier
+		}
+		return a.Timestamp > b.Timestamp
+	})
+}
+
+/*
+ToRow converts a thrift row result
+*/
+func ToRow(r *hbase1.TRowResult_) *Row {
+	if r == nil {
+		return nil
+	}
+
+	row := &Row{Key: []byte(r.Row), Cells: make([]*Cell, 0, len(r.Columns)+len(r.SortedColumns))}
+	for name, tc := range r.Columns {
+		row.Cells = append(row.Cells, toCell(name, tc))
+	}
+	for _, col := range r.SortedColumns {
+		row.Cells = append(row.Cells, toCell(string(col.ColumnName), col.Cell))
+	}
+	sortCells(row.Cells)
+	return row
+}
+
+/*
+ToRows converts thrift row results
+*/
+func ToRows(results []*hbase1.TRowResult_) []*Row {
+	if results == nil {
+		return nil
+	}
+
+	rows := make([]*Row, len(results))
+	for i, r := range results {
+		rows[i] = ToRow(r)
+	}
+	return rows
+}
+
+/*
+ToCells converts the cells of a column, as returned by Get and GetVer
+*/
+func ToCells(column string, cells []*hbase1.TCell) []*Cell {
+	if cells == nil {
+		return nil
+	}
+
+	data := make([]*Cell, len(cells))
+	for i, tc := range cells {
+		data[i] = toCell(column, tc)
+	}
+	sortCells(data)
+	return data
+}
+
+func toCell(column string, tc *hbase1.TCell) *Cell {
+	family, qualifier := SplitColumn(column)
+	c := &Cell{Family: family, Qualifier: qualifier}
+	if tc != nil {
+		c.Value = []byte(tc.Value)
+		c.Timestamp = tc.Timestamp
+	}
+	return c
+}
+
+func firstRow(results []*hbase1.TRowResult_) *Row {
+	if len(results) == 0 {
+		return nil
+	}
+	return ToRow(results[0])
+}
+
+/*
+ReadCells return the latest cell of the column, Get with goh cells
+*/
+func (client *HClient) ReadCells(tableName string, row []byte, column string, attributes map[string]string) ([]*Cell, error) {
+	data, err := client.Get(tableName, row, column, attributes)
+	return ToCells(column, data), err
+}
+
+/*
+ReadVersions return up to numVersions cells of the column, GetVer with
+goh cells
+*/
+func (client *HClient) ReadVersions(tableName string, row []byte, column string, numVersions int32, attributes map[string]string) ([]*Cell, error) {
+	data, err := client.GetVer(tableName, row, column, numVersions, attributes)
+	return ToCells(column, data), err
+}
+
+/*
+ReadVersionsTs return up to numVersions cells of the column not newer
+than timestamp, GetVerTs with goh cells
+*/
+func (client *HClient) ReadVersionsTs(tableName string, row []byte, column string, timestamp int64, numVersions int32, attributes map[string]string) ([]*Cell, error) {
+	data, err := client.GetVerTs(tableName, row, column, timestamp, numVersions, attributes)
+	return ToCells(column, data), err
+}
+
+/*
+ReadRow return the row, nil when it does not exist
+*/
+func (client *HClient) ReadRow(tableName string, row []byte, attributes map[string]string) (*Row, error) {
+	data, err := client.GetRow(tableName, row, attributes)
+	return firstRow(data), err
+}
+
+/*
+ReadRowWithColumns return the columns of the row, nil when it does not exist
+*/
+func (client *HClient) ReadRowWithColumns(tableName string, row []byte, columns []string, attributes map[string]string) (*Row, error) {
+	data, err := client.GetRowWithColumns(tableName, row, columns, attributes)
+	return firstRow(data), err
+}
+
+/*
+ReadRowTs return the row at timestamp, nil when it does not exist
+*/
+func (client *HClient) ReadRowTs(tableName string, row []byte, timestamp int64, attributes map[string]string) (*Row, error) {
+	data, err := client.GetRowTs(tableName, row, timestamp, attributes)
+	return firstRow(data), err
+}
+
+/*
+ReadRowWithColumnsTs return the columns of the row at timestamp, nil when
+it does not exist
+*/
+func (client *HClient) ReadRowWithColumnsTs(tableName string, row []byte, columns []string, timestamp int64, attributes map[string]string) (*Row, error) {
+	data, err := client.GetRowWithColumnsTs(tableName, row, columns, timestamp, attributes)
+	return firstRow(data), err
+}
+
+/*
+ReadRows return the rows which exist
+*/
+func (client *HClient) ReadRows(tableName string, rows [][]byte, attributes map[string]string) ([]*Row, error) {
+	data, err := client.GetRows(tableName, rows, attributes)
+	return ToRows(data), err
+}
+
+/*
+ReadRowsWithColumns return the columns of the rows which exist
+*/
+func (client *HClient) ReadRowsWithColumns(tableName string, rows [][]byte, columns []string, attributes map[string]string) ([]*Row, error) {
+	data, err := client.GetRowsWithColumns(tableName, rows, columns, attributes)
+	return ToRows(data), err
+}
+
+/*
+ReadRowsTs return the rows which exist at timestamp
+*/
+func (client *HClient) ReadRowsTs(tableName string, rows [][]byte, timestamp int64, attributes map[string]string) ([]*Row, error) {
+	data, err := client.GetRowsTs(tableName, rows, timestamp, attributes)
+	return ToRows(data), err
+}
+
+/*
+ReadRowsWithColumnsTs return the columns of the rows which exist at timestamp
+*/
+func (client *HClient) ReadRowsWithColumnsTs(tableName string, rows [][]byte, columns []string, timestamp int64, attributes map[string]string) ([]*Row, error) {
+	data, err := client.GetRowsWithColumnsTs(tableName, rows, columns, timestamp, attributes)
+	return ToRows(data), err
+}
+
+/*
+ScannerRead return the next row of the scanner, nil at the end
+*/
+func (client *HClient) ScannerRead(id int32) (*Row, error) {
+	data, err := client.ScannerGet(id)
+	return firstRow(data), err
+}
+
+/*
+ScannerReadList return up to nbRows next rows of the scanner, none at the end
+*/
+func (client *HClient) ScannerReadList(id int32, nbRows int32) ([]*Row, error) {
+	data, err := client.ScannerGetList(id, nbRows)
+	return ToRows(data), err
+}
@@ -0,0 +1,296 @@
+/*
+
+
+ */
+
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/chenjingping/goh"
+)
+
+/*
+ErrNotConfirmed is returned by Apply when a destructive step is refused
+*/
+var ErrNotConfirmed = errors.New("schema: destructive step not confirmed")
+
+/*
+Action is what a step does to a table
+*/
+type Action int
+
+/*
+Action
+*/
+const (
+	Create   Action = iota // create the table
+	Enable                 // enable the table
+	Disable                // disable the table
+	Recreate               // delete and create the table, its data is lost
+	Delete                 // delete the table, its data is lost
+)
+
+var actionNames = [...]string{"create", "enable", "disable", "recreate", "delete"}
+
+/*
+String
+*/
+func (a Action) String() string {
+	if a >= 0 && int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+/*
+Step is one change to one table
+*/
+type Step struct {
+	Action  Action
+	Table   string
+	Desired *Table   // nil for Enable, Disable and Delete
+	Changes []string // family differences, for Create and Recreate
+}
+
+/*
+Destructive reports whether the step loses data
+*/
+func (s Step) Destructive() bool {
+	return s.Action == Recreate || s.Action == Delete
+}
+
+/*
+String
+*/
+func (s Step) String() string {
+	var sb strings.Builder
+	mark := map[Action]string{Create: "+", Enable: "~", Disable: "~", Recreate: "!", Delete: "-"}[s.Action]
+	fmt.Fprintf(&sb, "%s %s table %s", mark, s.Action, s.Table)
+	if s.Destructive() {
+		sb.WriteString(" (destructive, data is lost)")
+	}
+	for _, c := range s.Changes {
+		sb.WriteString("\n    " + c)
+	}
+	return sb.String()
+}
+
+/*
+Plan is the ordered steps bringing the cluster to the schema
+*/
+type Plan struct {
+	Steps []Step
+}
+
+/*
+Empty reports whether the cluster already matches the schema
+*/
+func (p *Plan) Empty() bool {
+	return len(p.Steps) == 0
+}
+
+/*
+String prints the plan as a diff
+*/
+func (p *Plan) String() string {
+	if p.Empty() {
+		return "no changes\n"
+	}
+
+	var sb strings.Builder
+	for _, s := range p.Steps {
+		sb.WriteString(s.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+/*
+Diff compares the schema with the tables of the cluster
+*/
+func Diff(client *goh.HClient, s *Schema) (*Plan, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	names, err := client.GetTableNames()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	plan := &Plan{}
+	declared := make(map[string]bool, len(s.Tables))
+	for i := range s.Tables {
+		t := &s.Tables[i]
+		declared[t.Name] = true
+
+		if !existing[t.Name] {
+			var changes []string
+			for _, col := range t.Descriptors() {
+				changes = append(changes, "+ family "+describe(col))
+			}
+			plan.Steps = append(plan.Steps, Step{Action: Create, Table: t.Name, Desired: t, Changes: changes})
+			if t.Disabled {
+				plan.Steps = append(plan.Steps, Step{Action: Disable, Table: t.Name})
+			}
+			continue
+		}
+
+		have, err := client.GetColumnDescriptors(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if changes := diffFamilies(t.Descriptors(), have); changes != nil {
+			plan.Steps = append(plan.Steps, Step{Action: Recreate, Table: t.Name, Desired: t, Changes: changes})
+			if t.Disabled {
+				plan.Steps = append(plan.Steps, Step{Action: Disable, Table: t.Name})
+			}
+			continue
+		}
+
+		enabled, err := client.IsTableEnabled(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if enabled && t.Disabled {
+			plan.Steps = append(plan.Steps, Step{Action: Disable, Table: t.Name})
+		} else if !enabled && !t.Disabled {
+			plan.Steps = append(plan.Steps, Step{Action: Enable, Table: t.Name})
+		}
+	}
+
+	if s.Prune {
+		sort.Strings(names)
+		for _, name := range names {
+			if !declared[name] {
+				plan.Steps = append(plan.Steps, Step{Action: Delete, Table: name})
+			}
+		}
+	}
+	return plan, nil
+}
+
+/*
+Apply runs the steps in order. confirm is asked before each destructive
+step, a nil confirm refuses them; a refused step stops the plan with
+ErrNotConfirmed.
+*/
+func Apply(client *goh.HClient, plan *Plan, confirm func(step Step) bool) error {
+	for _, s := range plan.Steps {
+		if s.Destructive() && (confirm == nil || !confirm(s)) {
+			return fmt.Errorf("%w: %s table %s", ErrNotConfirmed, s.Action, s.Table)
+		}
+		if err := applyStep(client, s); err != nil {
+			return fmt.Errorf("schema: %s table %s: %w", s.Action, s.Table, err)
+		}
+	}
+	return nil
+}
+
+func applyStep(client *goh.HClient, s Step) error {
+	switch s.Action {
+	case Create:
+		_, err := client.CreateTable(s.Table, s.Desired.Descriptors())
+		return err
+	case Enable:
+		return client.EnableTable(s.Table)
+	case Disable:
+		return client.DisableTable(s.Table)
+	case Recreate, Delete:
+		enabled, err := client.IsTableEnabled(s.Table)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			if err = client.DisableTable(s.Table); err != nil {
+				return err
+			}
+		}
+		if err = client.DeleteTable(s.Table); err != nil {
+			return err
+		}
+		if s.Action == Recreate {
+			_, err = client.CreateTable(s.Table, s.Desired.Descriptors())
+		}
+		return err
+	}
+	return fmt.Errorf("unknown action %s", s.Action)
+}
+
+/*
+diffFamilies lists the differences between the wanted and the existing
+families, nil when there are none
+*/
+func diffFamilies(want []*goh.ColumnDescriptor, have map[string]*goh.ColumnDescriptor) []string {
+	var changes []string
+	seen := make(map[string]bool)
+	for _, w := range want {
+		seen[w.Name] = true
+		h, ok := have[w.Name]
+		if !ok {
+			changes = append(changes, "+ family "+describe(w))
+			continue
+		}
+
+		var diffs []string
+		if w.MaxVersions != h.MaxVersions {
+			diffs = append(diffs, fmt.Sprintf("maxVersions %d -> %d", h.MaxVersions, w.MaxVersions))
+		}
+		if !strings.EqualFold(w.Compression, h.Compression) {
+			diffs = append(diffs, fmt.Sprintf("compression %s -> %s", h.Compression, w.Compression))
+		}
+		if w.InMemory != h.InMemory {
+			diffs = append(diffs, fmt.Sprintf("inMemory %t -> %t", h.InMemory, w.InMemory))
+		}
+		if !strings.EqualFold(w.BloomFilterType, h.BloomFilterType) {
+			diffs = append(diffs, fmt.Sprintf("bloomFilterType %s -> %s", h.BloomFilterType, w.BloomFilterType))
+		}
+		if w.BlockCacheEnabled != h.BlockCacheEnabled {
+			diffs = append(diffs, fmt.Sprintf("blockCacheEnabled %t -> %t", h.BlockCacheEnabled, w.BlockCacheEnabled))
+		}
+		if ttl(w.TimeToLive) != ttl(h.TimeToLive) {
+			diffs = append(diffs, fmt.Sprintf("timeToLive %s -> %s", ttl(h.TimeToLive), ttl(w.TimeToLive)))
+		}
+		if diffs != nil {
+			changes = append(changes, "~ family "+strings.TrimSuffix(w.Name, ":")+": "+strings.Join(diffs, ", "))
+		}
+	}
+
+	var removed []string
+	for name := range have {
+		if !seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		changes = append(changes, "- family "+strings.TrimSuffix(name, ":"))
+	}
+	return changes
+}
+
+/*
+ttl prints a time to live, the server reports forever as MaxInt32
+*/
+func ttl(seconds int32) string {
+	if seconds <= 0 || seconds == math.MaxInt32 {
+		return "forever"
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+func describe(col *goh.ColumnDescriptor) string {
+	return fmt.Sprintf("%s maxVersions=%d compression=%s inMemory=%t bloomFilterType=%s blockCacheEnabled=%t timeToLive=%s",
+		strings.TrimSuffix(col.Name, ":"), col.MaxVersions, col.Compression, col.InMemory,
+		col.BloomFilterType, col.BlockCacheEnabled, ttl(col.TimeToLive))
+}
@@ -0,0 +1,161 @@
+/*
+Package schema brings the tables of a cluster to a declared state. A
+schema is written in JSON or built as Go values:
+
+	{
+		"tables": [
+			{
+				"name": "users",
+				"families": [
+					{"name": "info", "maxVersions": 1},
+					{"name": "stats", "timeToLive": 86400, "compression": "SNAPPY"}
+				]
+			}
+		],
+		"prune": false
+	}
+
+Diff compares it with the cluster and Apply runs the resulting plan.
+thrift1 can not alter a table, a family change recreates the table and
+loses its data, so such steps are only run once confirmed.
+*/
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chenjingping/goh"
+)
+
+/*
+Family describes a column family, zero fields take the defaults of
+goh.NewColumnDescriptorDefault
+*/
+type Family struct {
+	Name              string `json:"name"`
+	MaxVersions       int32  `json:"maxVersions,omitempty"`
+	Compression       string `json:"compression,omitempty"`
+	InMemory          bool   `json:"inMemory,omitempty"`
+	BloomFilterType   string `json:"bloomFilterType,omitempty"`
+	BlockCacheEnabled bool   `json:"blockCacheEnabled,omitempty"`
+	TimeToLive        int32  `json:"timeToLive,omitempty"` // seconds, 0 keeps cells forever
+}
+
+/*
+Table describes a table
+*/
+type Table struct {
+	Name     string   `json:"name"`
+	Families []Family `json:"families"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+/*
+Schema is the desired state of the cluster
+*/
+type Schema struct {
+	Tables []Table `json:"tables"`
+	Prune  bool    `json:"prune,omitempty"` // delete the tables the schema does not list
+}
+
+/*
+Load reads a JSON schema file
+*/
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+/*
+Parse decodes and validates a JSON schema, unknown fields are errors
+*/
+func Parse(data []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	s := &Schema{}
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("schema: %v", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+/*
+Validate checks names are set and unique
+*/
+func (s *Schema) Validate() error {
+	tables := make(map[string]bool)
+	for _, t := range s.Tables {
+		if t.Name == "" {
+			return fmt.Errorf("schema: table without name")
+		}
+		if tables[t.Name] {
+			return fmt.Errorf("schema: table %s declared twice", t.Name)
+		}
+		tables[t.Name] = true
+
+		if len(t.Families) == 0 {
+			return fmt.Errorf("schema: table %s has no family", t.Name)
+		}
+		families := make(map[string]bool)
+		for _, f := range t.Families {
+			name := strings.TrimSuffix(f.Name, ":")
+			if name == "" || strings.Contains(name, ":") {
+				return fmt.Errorf("schema: table %s: invalid family name %q", t.Name, f.Name)
+			}
+			if families[name] {
+				return fmt.Errorf("schema: table %s: family %s declared twice", t.Name, name)
+			}
+			families[name] = true
+		}
+	}
+	return nil
+}
+
+/*
+Descriptor return the column descriptor of the family
+*/
+func (f Family) Descriptor() *goh.ColumnDescriptor {
+	col := goh.NewColumnDescriptorDefault(strings.TrimSuffix(f.Name, ":") + ":")
+	if f.MaxVersions > 0 {
+		col.MaxVersions = f.MaxVersions
+	}
+	if f.Compression != "" {
+		col.Compression = strings.ToUpper(f.Compression)
+	}
+	if f.BloomFilterType != "" {
+		col.BloomFilterType = strings.ToUpper(f.BloomFilterType)
+	}
+	if f.TimeToLive > 0 {
+		col.TimeToLive = f.TimeToLive
+	}
+	col.InMemory = f.InMemory
+	col.BlockCacheEnabled = f.BlockCacheEnabled
+	return col
+}
+
+/*
+Descriptors return the column descriptors of the table
+*/
+func (t Table) Descriptors() []*goh.ColumnDescriptor {
+	cols := make([]*goh.ColumnDescriptor, len(t.Families))
+	for i, f := range t.Families {
+		cols[i] = f.Descriptor()
+	}
+	return cols
+}
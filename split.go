@@ -0,0 +1,296 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenjingping/goh/filter"
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+The split planners return the split points of a pre-split table, regions
+split points give regions+1 regions. thrift1 creates tables without split
+points and the thrift2 service of this package has no admin calls, so a
+pre-split table is created with the command ShellCreate prints.
+*/
+
+/*
+HexSplits return regions-1 points spreading the keys which start with
+width hex digits, as md5 prefixed keys do, evenly over regions
+*/
+func HexSplits(regions, width int) [][]byte {
+	return uniformSplits(regions, width, 16)
+}
+
+/*
+DecimalSplits return regions-1 points spreading the keys which start with
+width decimal digits evenly over regions
+*/
+func DecimalSplits(regions, width int) [][]byte {
+	return uniformSplits(regions, width, 10)
+}
+
+func uniformSplits(regions, width, base int) [][]byte {
+	if regions < 2 || width < 1 {
+		return nil
+	}
+
+	space := new(big.Int).Exp(big.NewInt(int64(base)), big.NewInt(int64(width)), nil)
+	var splits [][]byte
+	for i := 1; i < regions; i++ {
+		p := new(big.Int).Mul(space, big.NewInt(int64(i)))
+		p.Div(p, big.NewInt(int64(regions)))
+		s := p.Text(base)
+		if len(s) < width {
+			s = strings.Repeat("0", width-len(s)) + s
+		}
+		if len(splits) == 0 || string(splits[len(splits)-1]) != s {
+			splits = append(splits, []byte(s))
+		}
+	}
+	return splits
+}
+
+/*
+SaltWidth return the number of digits of the salt prefixes of buckets,
+less than one bucket counts as one
+*/
+func SaltWidth(buckets int) int {
+	if buckets < 1 {
+		buckets = 1
+	}
+	return len(strconv.Itoa(buckets - 1))
+}
+
+/*
+Salt prefixes key with its bucket, a zero padded decimal number computed
+from the key hash, so consecutive keys land in different regions. Less
+than one bucket counts as one.
+*/
+func Salt(key []byte, buckets int) []byte {
+	if buckets < 1 {
+		buckets = 1
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	prefix := fmt.Sprintf("%0*d", SaltWidth(buckets), h.Sum32()%uint32(buckets))
+	return append([]byte(prefix), key...)
+}
+
+/*
+SaltedSplits return one region per salt bucket, no split for less than
+two buckets
+*/
+func SaltedSplits(buckets int) [][]byte {
+	var splits [][]byte
+	for i := 1; i < buckets; i++ {
+		splits = append(splits, []byte(fmt.Sprintf("%0*d", SaltWidth(buckets), i)))
+	}
+	return splits
+}
+
+/*
+SampledSplits scans the row keys of an existing table, keeps a uniform
+sample of up to sample keys and return the regions-1 keys dividing it in
+equal parts
+*/
+func SampledSplits(client *HClient, tableName string, regions, sample int) ([][]byte, error) {
+	if regions < 2 {
+		return nil, nil
+	}
+	if sample < regions {
+		sample = regions * 100
+	}
+
+	scan := &TScan{Caching: 1000}
+	scan.SetFilter(filter.And(filter.FirstKeyOnly(), filter.KeyOnly()))
+
+	keys := make([][]byte, 0, sample)
+	seen := 0
+	err := scanAll(client, tableName, scan, nil, func(r *hbase1.TRowResult_) error {
+		seen++
+		if len(keys) < sample {
+			keys = append(keys, []byte(r.Row))
+		} else if i := rand.Intn(seen); i < sample {
+			keys[i] = []byte(r.Row)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	var splits [][]byte
+	for i := 1; i < regions && len(keys) > 0; i++ {
+		k := keys[i*len(keys)/regions]
+		if len(splits) == 0 || !bytes.Equal(splits[len(splits)-1], k) {
+			splits = append(splits, k)
+		}
+	}
+	return splits, nil
+}
+
+/*
+ShellCreate return the hbase shell command creating a pre-split table
+*/
+func ShellCreate(tableName string, columnFamilies []*ColumnDescriptor, splits [][]byte) string {
+	var sb strings.Builder
+	sb.WriteString("create " + shellQuote([]byte(tableName)))
+	for _, col := range columnFamilies {
+		fmt.Fprintf(&sb, ", {NAME => %s, VERSIONS => %d", shellQuote([]byte(strings.TrimSuffix(col.Name, ":"))), col.MaxVersions)
+		if col.Compression != "" {
+			fmt.Fprintf(&sb, ", COMPRESSION => '%s'", col.Compression)
+		}
+		if col.BloomFilterType != "" {
+			fmt.Fprintf(&sb, ", BLOOMFILTER => '%s'", col.BloomFilterType)
+		}
+		if col.InMemory {
+			sb.WriteString(", IN_MEMORY => 'true'")
+		}
+		if col.TimeToLive > 0 {
+			fmt.Fprintf(&sb, ", TTL => %d", col.TimeToLive)
+		}
+		sb.WriteString("}")
+	}
+
+	if len(splits) > 0 {
+		parts := make([]string, len(splits))
+		for i, s := range splits {
+			parts[i] = shellQuote(s)
+		}
+		sb.WriteString(", SPLITS => [" + strings.Join(parts, ", ") + "]")
+	}
+	return sb.String()
+}
+
+/*
+shellQuote quotes bytes for the hbase (ruby) shell, double quoted strings
+with binary bytes escaped
+*/
+func shellQuote(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\' || c == '#':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\x%02X", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+/*
+regionRanges return the regions of the table clipped to kr, in key order,
+kr itself when the table lists no regions
+*/
+func regionRanges(client *HClient, tableName string, kr KeyRange) ([]KeyRange, error) {
+	regions, err := client.GetTableRegions(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(regions) == 0 {
+		return []KeyRange{kr}, nil
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].StartKey < regions[j].StartKey })
+
+	var ranges []KeyRange
+	for _, region := range regions {
+		start, stop := []byte(region.StartKey), []byte(region.EndKey)
+		if bytes.Compare(start, kr.Start) < 0 {
+			start = kr.Start
+		}
+		if len(kr.Stop) > 0 && (len(stop) == 0 || bytes.Compare(kr.Stop, stop) < 0) {
+			stop = kr.Stop
+		}
+		if len(stop) > 0 && bytes.Compare(start, stop) >= 0 {
+			continue
+		}
+		ranges = append(ranges, KeyRange{Start: start, Stop: stop})
+	}
+	return ranges, nil
+}
+
+/*
+forEachRange calls fn for the ranges, parallel calls at a time, and return
+the first error, which cancels the calls still running
+*/
+func forEachRange[R any](ctx context.Context, ranges []R, parallel int, fn func(ctx context.Context, r R) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	todo := make(chan R, len(ranges))
+	for _, r := range ranges {
+		todo <- r
+	}
+	close(todo)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range todo {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := fn(ctx, r); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+/*
+everyInterval calls fn every interval until the returned stop is called
+*/
+func everyInterval(interval time.Duration, fn func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				fn()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,347 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+ErrSpoolFull is returned by a flush which could neither send nor spool
+its mutations because the spool reached MaxBytes
+*/
+var ErrSpoolFull = errors.New("goh: spool is full")
+
+/*
+SyncPolicy tells when spooled mutations are synced to disk
+*/
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // fsync each spooled batch
+	SyncPeriodic                   // fsync at most every SyncInterval
+	SyncNever                      // leave it to the operating system
+)
+
+/*
+SpoolConfig sets where a BufferedMutator spools the mutations it could
+not send and how much it keeps
+*/
+type SpoolConfig struct {
+	Dir           string        // directory of the segment files, created when missing
+	SegmentBytes  int64         // a segment is closed past this size, 16 MiB by default
+	MaxBytes      int64         // backlog cap, 1 GiB by default
+	Sync          SyncPolicy    // SyncAlways by default
+	SyncInterval  time.Duration // for SyncPeriodic, 1s by default
+	RetryInterval time.Duration // between replay attempts, 5s by default
+}
+
+/*
+spoolRecord is a batch of mutations sharing a timestamp, 0 for server time
+*/
+type spoolRecord struct {
+	Timestamp int64                   `json:"ts"`
+	Rows      []*hbase1.BatchMutation `json:"rows"`
+}
+
+/*
+spool is a log of records in numbered segment files. A record is its
+length and crc32 followed by its JSON; the replay position is kept in the
+cursor file and a segment is removed once replayed.
+*/
+type spool struct {
+	conf SpoolConfig
+
+	segments []int64 // sequence numbers, oldest first
+	w        *os.File
+	wseq     int64
+	wsize    int64
+	lastSync time.Time
+
+	rseq int64 // replay position
+	roff int64
+
+	records int64 // backlog
+	bytes   int64
+}
+
+const spoolHeader = 8
+
+func (s *spool) segmentName(seq int64) string {
+	return filepath.Join(s.conf.Dir, fmt.Sprintf("%016d.spool", seq))
+}
+
+func (s *spool) cursorName() string {
+	return filepath.Join(s.conf.Dir, "cursor")
+}
+
+/*
+openSpool opens the segments left in the directory, the last one is cut
+after its last whole record
+*/
+func openSpool(conf SpoolConfig) (*spool, error) {
+	if conf.SegmentBytes <= 0 {
+		conf.SegmentBytes = 16 << 20
+	}
+	if conf.MaxBytes <= 0 {
+		conf.MaxBytes = 1 << 30
+	}
+	if conf.SyncInterval <= 0 {
+		conf.SyncInterval = time.Second
+	}
+	if conf.RetryInterval <= 0 {
+		conf.RetryInterval = 5 * time.Second
+	}
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{conf: conf}
+	names, err := filepath.Glob(filepath.Join(conf.Dir, "*.spool"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), ".spool"), 10, 64)
+		if err == nil {
+			s.segments = append(s.segments, seq)
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if data, err := os.ReadFile(s.cursorName()); err == nil {
+		fmt.Sscan(string(data), &s.rseq, &s.roff)
+	}
+	if len(s.segments) > 0 && s.rseq < s.segments[0] {
+		s.rseq, s.roff = s.segments[0], 0
+	}
+
+	// count the backlog, cutting a record torn by a crash
+	for i, seq := range s.segments {
+		off := int64(0)
+		if seq == s.rseq {
+			off = s.roff
+		}
+		if seq < s.rseq {
+			continue
+		}
+		end, n, err := s.scanSegment(seq, off)
+		if err != nil {
+			return nil, err
+		}
+		s.records += n
+		s.bytes += end - off
+		if i == len(s.segments)-1 {
+			if err = os.Truncate(s.segmentName(seq), end); err != nil {
+				return nil, err
+			}
+			s.wseq, s.wsize = seq, end
+		}
+	}
+	return s, nil
+}
+
+/*
+scanSegment return the end of the last whole record from off and the
+number of records
+*/
+func (s *spool) scanSegment(seq, off int64) (int64, int64, error) {
+	f, err := os.Open(s.segmentName(seq))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	n := int64(0)
+	for {
+		_, size, err := readSpoolRecord(f, off)
+		if err != nil {
+			return off, n, nil
+		}
+		off += size
+		n++
+	}
+}
+
+/*
+readSpoolRecord reads the record at off and return its size on disk
+*/
+func readSpoolRecord(f *os.File, off int64) (*spoolRecord, int64, error) {
+	var header [spoolHeader]byte
+	if _, err := f.ReadAt(header[:], off); err != nil {
+		return nil, 0, err
+	}
+	n := binary.BigEndian.Uint32(header[:4])
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, off+spoolHeader); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("goh: spool record checksum mismatch")
+	}
+
+	rec := &spoolRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, 0, err
+	}
+	return rec, spoolHeader + int64(n), nil
+}
+
+/*
+append adds a record at the end of the log
+*/
+func (s *spool) append(rec *spoolRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	size := spoolHeader + int64(len(data))
+	if s.bytes+size > s.conf.MaxBytes {
+		return ErrSpoolFull
+	}
+
+	if s.w == nil || s.wsize+size > s.conf.SegmentBytes && s.wsize > 0 {
+		if err = s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[spoolHeader:], data)
+	if _, err = s.w.Write(buf); err != nil {
+		return err
+	}
+	s.wsize += size
+	s.records++
+	s.bytes += size
+
+	switch s.conf.Sync {
+	case SyncAlways:
+		return s.w.Sync()
+	case SyncPeriodic:
+		if time.Since(s.lastSync) >= s.conf.SyncInterval {
+			s.lastSync = time.Now()
+			return s.w.Sync()
+		}
+	}
+	return nil
+}
+
+/*
+rotate opens the segment appended to: the last one when it has room,
+a new one otherwise
+*/
+func (s *spool) rotate() error {
+	if s.w != nil {
+		s.w.Sync()
+		s.w.Close()
+		s.w = nil
+	}
+
+	n := len(s.segments)
+	if n == 0 || s.wsize >= s.conf.SegmentBytes || s.wseq != s.segments[n-1] {
+		seq := int64(1)
+		if n > 0 {
+			seq = s.segments[n-1] + 1
+		}
+		s.segments = append(s.segments, seq)
+		s.wseq, s.wsize = seq, 0
+		if n == 0 {
+			s.rseq, s.roff = seq, 0
+		}
+	}
+
+	f, err := os.OpenFile(s.segmentName(s.wseq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.w = f
+	return nil
+}
+
+/*
+peek return the oldest record and its size, nil when the log is empty
+*/
+func (s *spool) peek() (*spoolRecord, int64, error) {
+	for s.records > 0 && len(s.segments) > 0 {
+		f, err := os.Open(s.segmentName(s.rseq))
+		if err != nil {
+			return nil, 0, err
+		}
+		rec, size, err := readSpoolRecord(f, s.roff)
+		f.Close()
+		if err == nil {
+			return rec, size, nil
+		}
+		if s.rseq == s.wseq {
+			// the segment being written has nothing past the cursor
+			return nil, 0, nil
+		}
+
+		// end of a finished segment
+		os.Remove(s.segmentName(s.rseq))
+		s.segments = s.segments[1:]
+		s.rseq, s.roff = s.segments[0], 0
+		s.saveCursor()
+	}
+	return nil, 0, nil
+}
+
+/*
+advance moves the replay position past the record peek returned
+*/
+func (s *spool) advance(size int64) error {
+	s.roff += size
+	s.records--
+	s.bytes -= size
+	return s.saveCursor()
+}
+
+func (s *spool) saveCursor() error {
+	tmp := s.cursorName() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", s.rseq, s.roff)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cursorName())
+}
+
+func (s *spool) close() error {
+	if s.w == nil {
+		return nil
+	}
+	err := s.w.Sync()
+	if e := s.w.Close(); err == nil {
+		err = e
+	}
+	s.w = nil
+	return err
+}
+
+/*
+isTransient reports whether a write may succeed later: the breaker or the
+rate limit rejected it, the connection broke or the gateway could not
+reach the region servers
+*/
+func isTransient(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	return isBreakerFailure(err)
+}
@@ -0,0 +1,275 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+HTable is a table bound to a client. The column descriptors and regions
+are loaded on first use and kept until Refresh or until the server reports
+a schema error; mutations naming an unknown family are rejected before
+they are sent.
+*/
+type HTable struct {
+	client *HClient
+	name   string
+
+	mu       sync.Mutex
+	families map[string]*ColumnDescriptor
+	regions  []*TRegionInfo
+}
+
+/*
+Table return a handle on the table, it makes no call
+*/
+func (client *HClient) Table(name string) *HTable {
+	return &HTable{client: client, name: name}
+}
+
+/*
+Name return the table name
+*/
+func (t *HTable) Name() string {
+	return t.name
+}
+
+/*
+Client return the client the table is bound to
+*/
+func (t *HTable) Client() *HClient {
+	return t.client
+}
+
+/*
+Families return the cached column descriptors of the table
+*/
+func (t *HTable) Families() (map[string]*ColumnDescriptor, error) {
+	t.mu.Lock()
+	families := t.families
+	t.mu.Unlock()
+	if families != nil {
+		return families, nil
+	}
+
+	families, err := t.client.GetColumnDescriptors(t.name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.families = families
+	t.mu.Unlock()
+	return families, nil
+}
+
+/*
+Regions return the cached regions of the table
+*/
+func (t *HTable) Regions() ([]*TRegionInfo, error) {
+	t.mu.Lock()
+	regions := t.regions
+	t.mu.Unlock()
+	if regions != nil {
+		return regions, nil
+	}
+
+	regions, err := t.client.GetTableRegions(t.name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.regions = regions
+	t.mu.Unlock()
+	return regions, nil
+}
+
+/*
+Refresh drops the cached column descriptors and regions
+*/
+func (t *HTable) Refresh() {
+	t.mu.Lock()
+	t.families = nil
+	t.regions = nil
+	t.mu.Unlock()
+}
+
+/*
+IsSchemaError reports whether err says a table or column family does not
+exist, the schema the client knows is stale
+*/
+func IsSchemaError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, name := range []string{
+		"NoSuchColumnFamilyException",
+		"TableNotFoundException",
+		"TableNotEnabledException",
+	} {
+		if strings.Contains(msg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+checked drops the cache when the server reports a schema error
+*/
+func (t *HTable) checked(err error) error {
+	if IsSchemaError(err) {
+		t.Refresh()
+	}
+	return err
+}
+
+/*
+validate runs check on the cached families. A family may have been added
+since they were read, on ErrUnknownFamily the cache is refreshed and
+check runs once more.
+*/
+func (t *HTable) validate(check func(families map[string]*ColumnDescriptor) error) error {
+	families, err := t.Families()
+	if err != nil {
+		return err
+	}
+	if err = check(families); !errors.Is(err, ErrUnknownFamily) {
+		return err
+	}
+
+	t.Refresh()
+	if families, err = t.Families(); err != nil {
+		return err
+	}
+	return check(families)
+}
+
+/*
+checkColumns rejects columns whose family the table lacks
+*/
+func (t *HTable) checkColumns(columns ...string) error {
+	return t.validate(func(families map[string]*ColumnDescriptor) error {
+		for _, col := range columns {
+			family, _ := SplitColumn(col)
+			if !hasFamily(families, family) {
+				return fmt.Errorf("%w: %s in table %s", ErrUnknownFamily, family, t.name)
+			}
+		}
+		return nil
+	})
+}
+
+/*
+Get return the row, or only the given columns of it, nil when it does not
+exist
+*/
+func (t *HTable) Get(row []byte, columns []string, attributes map[string]string) (*Row, error) {
+	if columns == nil {
+		data, err := t.client.ReadRow(t.name, row, attributes)
+		return data, t.checked(err)
+	}
+	data, err := t.client.ReadRowWithColumns(t.name, row, columns, attributes)
+	return data, t.checked(err)
+}
+
+/*
+GetRows return the rows which exist, or only the given columns of them
+*/
+func (t *HTable) GetRows(rows [][]byte, columns []string, attributes map[string]string) ([]*Row, error) {
+	if columns == nil {
+		data, err := t.client.ReadRows(t.name, rows, attributes)
+		return data, t.checked(err)
+	}
+	data, err := t.client.ReadRowsWithColumns(t.name, rows, columns, attributes)
+	return data, t.checked(err)
+}
+
+/*
+Put sets the value of a family:qualifier column
+*/
+func (t *HTable) Put(row []byte, column string, value []byte, attributes map[string]string) error {
+	return t.Mutate(NewRowMutation(row).Put(column, value), attributes)
+}
+
+/*
+Mutate checks the mutation against the cached families and sends it
+*/
+func (t *HTable) Mutate(m *RowMutation, attributes map[string]string) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	err := t.validate(func(families map[string]*ColumnDescriptor) error {
+		if err := m.Validate(families); err != nil {
+			return fmt.Errorf("%w in table %s", err, t.name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return t.checked(t.client.sendRowMutation(t.name, m, attributes))
+}
+
+/*
+Delete deletes a column, a family, or the whole row when column is empty
+*/
+func (t *HTable) Delete(row []byte, column string, attributes map[string]string) error {
+	if column == "" {
+		return t.checked(t.client.DeleteAllRow(t.name, row, attributes))
+	}
+
+	if err := t.checkColumns(column); err != nil {
+		return err
+	}
+	return t.checked(t.client.DeleteAll(t.name, row, column, attributes))
+}
+
+/*
+Scan calls fn for each row of the scan until fn fails, the scanner is
+closed on return
+*/
+func (t *HTable) Scan(scan *TScan, attributes map[string]string, fn func(row *Row) error) error {
+	err := scanAll(t.client, t.name, scan, attributes, func(r *hbase1.TRowResult_) error {
+		return fn(ToRow(r))
+	})
+	return t.checked(err)
+}
+
+/*
+Increment atomically adds amount to a column and return the new value
+*/
+func (t *HTable) Increment(row []byte, column string, amount int64) (int64, error) {
+	if err := t.checkColumns(column); err != nil {
+		return 0, err
+	}
+
+	v, err := t.client.AtomicIncrement(t.name, row, column, amount)
+	return v, t.checked(err)
+}
+
+/*
+CheckAndPut sets putColumn to value when column holds expected, or when
+column is absent if expected is nil, and reports whether the put was done
+*/
+func (t *HTable) CheckAndPut(row []byte, column string, expected []byte, putColumn string, value []byte, attributes map[string]string) (bool, error) {
+	if err := t.checkColumns(column, putColumn); err != nil {
+		return false, err
+	}
+
+	ok, err := t.client.CheckAndPut(t.name, row, column, expected, NewMutation(putColumn, value), attributes)
+	return ok, t.checked(err)
+}
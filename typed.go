@@ -0,0 +1,241 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+KeyRange is a range of row keys, Start included and Stop excluded, an
+empty bound is open
+*/
+type KeyRange struct {
+	Start []byte
+	Stop  []byte
+}
+
+/*
+Table stores values of a struct type T in a table, one row per value. T
+is mapped with hbase tags, see StructCodec, and key gives the row key of
+a value.
+*/
+type Table[T any] struct {
+	client *HClient
+	name   string
+	key    func(T) []byte
+	codec  *StructCodec
+}
+
+/*
+NewTable return a typed table, key defaults to the rowkey tagged field
+*/
+func NewTable[T any](client *HClient, name string, key func(T) []byte) (*Table[T], error) {
+	// TypeOf a nil interface is nil, take the type from a pointer
+	codec, err := CodecOf(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if codec.key == nil {
+			return nil, fmt.Errorf("goh: type %s has no rowkey field and no key function", codec.typ)
+		}
+		key = func(v T) []byte { return codec.Key(v) }
+	}
+	return &Table[T]{client: client, name: name, key: key, codec: codec}, nil
+}
+
+/*
+Name return the table name
+*/
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+func (t *Table[T]) decode(row *Row) (T, error) {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.Ptr {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		return v, t.codec.Decode(row, rv.Interface())
+	}
+	return v, t.codec.Decode(row, &v)
+}
+
+/*
+Get return the value stored at key, ErrNotFound when the row does not
+exist
+*/
+func (t *Table[T]) Get(ctx context.Context, key []byte) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	row, err := t.client.ReadRowWithColumns(t.name, key, t.codec.columns, nil)
+	if err != nil {
+		return zero, err
+	}
+	if row == nil {
+		return zero, ErrNotFound
+	}
+	return t.decode(row)
+}
+
+/*
+check rejects a nil pointer value, which has no key nor fields
+*/
+func (t *Table[T]) check(v T) error {
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return fmt.Errorf("%w: %s in table %s", ErrNilValue, rv.Type(), t.name)
+	}
+	return nil
+}
+
+/*
+Put stores v under its key
+*/
+func (t *Table[T]) Put(ctx context.Context, v T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := t.check(v); err != nil {
+		return err
+	}
+	return t.client.MutateRow(t.name, t.key(v), t.codec.Mutations(v), nil)
+}
+
+/*
+BatchPut stores the values in one MutateRows call
+*/
+func (t *Table[T]) BatchPut(ctx context.Context, values []T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	batches := make([]*hbase1.BatchMutation, len(values))
+	for i, v := range values {
+		if err := t.check(v); err != nil {
+			return err
+		}
+		batches[i] = NewBatchMutation(t.key(v), t.codec.Mutations(v))
+	}
+	return t.client.MutateRows(t.name, batches, nil)
+}
+
+/*
+Delete deletes the row at key
+*/
+func (t *Table[T]) Delete(ctx context.Context, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.client.DeleteAllRow(t.name, key, nil)
+}
+
+/*
+Scan return an iterator over the values in the key range, it must be
+closed
+*/
+func (t *Table[T]) Scan(ctx context.Context, r KeyRange) (*Iterator[T], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	id, err := t.client.ScannerOpenWithScan(t.name, &TScan{
+		StartRow: r.Start,
+		StopRow:  r.Stop,
+		Columns:  t.codec.columns,
+		Caching:  100,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator[T]{ctx: ctx, table: t, id: id, open: true}, nil
+}
+
+/*
+Iterator walks the values of a scan:
+
+	it, err := users.Scan(ctx, goh.KeyRange{Start: []byte("u1")})
+	if err != nil { ... }
+	defer it.Close()
+	for it.Next() {
+		u := it.Value()
+	}
+	if err := it.Err(); err != nil { ... }
+*/
+type Iterator[T any] struct {
+	ctx   context.Context
+	table *Table[T]
+	id    int32
+	open  bool
+	rows  []*Row
+	value T
+	err   error
+}
+
+/*
+Next advances to the next value, false at the end or on error
+*/
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil || !it.open {
+		return false
+	}
+
+	if len(it.rows) == 0 {
+		if it.err = it.ctx.Err(); it.err != nil {
+			it.Close()
+			return false
+		}
+		it.rows, it.err = it.table.client.ScannerReadList(it.id, 100)
+		if it.err != nil || len(it.rows) == 0 {
+			it.Close()
+			return false
+		}
+	}
+
+	row := it.rows[0]
+	it.rows = it.rows[1:]
+	if it.value, it.err = it.table.decode(row); it.err != nil {
+		it.Close()
+		return false
+	}
+	return true
+}
+
+/*
+Value return the current value
+*/
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+/*
+Err return the error which stopped the iteration
+*/
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+/*
+Close closes the scanner, it may be called more than once
+*/
+func (it *Iterator[T]) Close() error {
+	if !it.open {
+		return nil
+	}
+	it.open = false
+	return it.table.client.ScannerClose(it.id)
+}
@@ -0,0 +1,414 @@
+/*
+
+
+ */
+
+package goh
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"hash"
+	"sync"
+
+	"github.com/chenjingping/goh/hbase1"
+)
+
+/*
+VerifyConfig selects what Verify compares and whether it repairs
+*/
+type VerifyConfig struct {
+	Range      KeyRange
+	Columns    []string // families or family:qualifier columns, all when empty
+	Timestamps bool     // compare the cell timestamps too, not only the values
+	ChunkRows  int      // source rows hashed together, 1000 by default
+	Parallel   int      // regions verified at once, 4 by default
+	MaxDiffs   int      // stop drilling down after this many differing rows, 0 for no limit
+
+	// Repair writes the source cells of differing rows to the target and
+	// deletes the rows and columns the source does not have. A target
+	// cell newer than the source one is overwritten at server time.
+	Repair bool
+
+	Report func(d *RowDiff) // called for each differing row, one call at a time
+}
+
+/*
+DiffKind tells how a row differs
+*/
+type DiffKind int
+
+const (
+	OnlyInSource DiffKind = iota // the row is missing from the target
+	OnlyInTarget                 // the row is missing from the source
+	Different                    // the row is in both tables with different cells
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case OnlyInSource:
+		return "only in source"
+	case OnlyInTarget:
+		return "only in target"
+	}
+	return "different"
+}
+
+/*
+RowDiff is a row which differs between the tables, Source or Target is
+nil when the row is missing from that side
+*/
+type RowDiff struct {
+	Key    []byte
+	Kind   DiffKind
+	Source *Row
+	Target *Row
+}
+
+/*
+VerifyResult counts what Verify compared and found
+*/
+type VerifyResult struct {
+	Chunks     int64 // key ranges hashed on both sides
+	Mismatched int64 // chunks whose hashes differ
+	SourceRows int64
+	TargetRows int64
+	Diffs      int64 // differing rows found by the drill down
+	Repaired   int64 // rows written or deleted in the target
+	Truncated  bool  // the drill down stopped at MaxDiffs
+}
+
+/*
+chunk is a key range with the hash of its rows on one side
+*/
+type chunk struct {
+	KeyRange
+	rows int64
+	sum  [md5.Size]byte
+}
+
+type verifier struct {
+	src      *Pool
+	dst      *Pool
+	srcTable string
+	dstTable string
+	conf     VerifyConfig
+
+	mu     sync.Mutex // serializes Report and guards result
+	result VerifyResult
+}
+
+/*
+Verify compares two tables in the way of HBase's HashTable and SyncTable.
+The source regions are scanned in parallel and cut in chunks of ChunkRows
+rows, the target is scanned over the same chunks and the chunks whose
+hashes differ are compared row by row. Only the latest version of each
+column is compared.
+*/
+func Verify(ctx context.Context, src, dst *Pool, srcTable, dstTable string, conf VerifyConfig) (*VerifyResult, error) {
+	if conf.ChunkRows <= 0 {
+		conf.ChunkRows = 1000
+	}
+	if conf.Parallel <= 0 {
+		conf.Parallel = 4
+	}
+
+	v := &verifier{src: src, dst: dst, srcTable: srcTable, dstTable: dstTable, conf: conf}
+
+	client, err := src.Get()
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := regionRanges(client, srcTable, conf.Range)
+	src.Put(client, err)
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachRange(ctx, ranges, conf.Parallel, v.verifyRange)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	result := v.result
+	return &result, err
+}
+
+func (v *verifier) scan(r KeyRange) *TScan {
+	return &TScan{StartRow: r.Start, StopRow: r.Stop, Columns: v.conf.Columns, Caching: 100}
+}
+
+/*
+hashRow adds the key and cells of the row to h
+*/
+func (v *verifier) hashRow(h hash.Hash, row *Row) {
+	var n [8]byte
+	write := func(b []byte) {
+		binary.BigEndian.PutUint32(n[:4], uint32(len(b)))
+		h.Write(n[:4])
+		h.Write(b)
+	}
+
+	write(row.Key)
+	for _, c := range row.Cells {
+		write([]byte(c.Column()))
+		write(c.Value)
+		if v.conf.Timestamps {
+			binary.BigEndian.PutUint64(n[:], uint64(c.Timestamp))
+			h.Write(n[:])
+		}
+	}
+}
+
+func (v *verifier) verifyRange(ctx context.Context, r KeyRange) (err error) {
+	from, err := v.src.Get()
+	if err != nil {
+		return err
+	}
+	defer func() { v.src.Put(from, err) }()
+	to, err := v.dst.Get()
+	if err != nil {
+		return err
+	}
+	defer func() { v.dst.Put(to, err) }()
+
+	chunks, err := v.sourceChunks(ctx, from, r)
+	if err != nil {
+		return err
+	}
+	targets, err := v.targetChunks(ctx, to, chunks)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range chunks {
+		t := targets[i]
+		v.mu.Lock()
+		v.result.Chunks++
+		v.result.SourceRows += c.rows
+		v.result.TargetRows += t.rows
+		match := c.rows == t.rows && c.sum == t.sum
+		if !match {
+			v.result.Mismatched++
+		}
+		truncated := v.result.Truncated
+		v.mu.Unlock()
+
+		if !match && !truncated {
+			if err = v.diffChunk(ctx, from, to, c.KeyRange); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+sourceChunks cuts the range in chunks of ChunkRows rows, a chunk ends at
+the first row of the next one
+*/
+func (v *verifier) sourceChunks(ctx context.Context, client *HClient, r KeyRange) ([]*chunk, error) {
+	cur := &chunk{KeyRange: KeyRange{Start: r.Start}}
+	h := md5.New()
+	var chunks []*chunk
+
+	err := scanAll(client, v.srcTable, v.scan(r), nil, func(tr *hbase1.TRowResult_) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if cur.rows >= int64(v.conf.ChunkRows) {
+			cur.Stop = tr.Row
+			h.Sum(cur.sum[:0])
+			chunks = append(chunks, cur)
+			cur = &chunk{KeyRange: KeyRange{Start: tr.Row}}
+			h.Reset()
+		}
+		v.hashRow(h, ToRow(tr))
+		cur.rows++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cur.Stop = r.Stop
+	h.Sum(cur.sum[:0])
+	return append(chunks, cur), nil
+}
+
+/*
+targetChunks hashes the target rows over the chunks of the source
+*/
+func (v *verifier) targetChunks(ctx context.Context, client *HClient, chunks []*chunk) ([]*chunk, error) {
+	targets := make([]*chunk, len(chunks))
+	for i, c := range chunks {
+		targets[i] = &chunk{KeyRange: c.KeyRange}
+	}
+
+	i := 0
+	h := md5.New()
+	next := func() {
+		h.Sum(targets[i].sum[:0])
+		h.Reset()
+		i++
+	}
+
+	r := KeyRange{Start: chunks[0].Start, Stop: chunks[len(chunks)-1].Stop}
+	err := scanAll(client, v.dstTable, v.scan(r), nil, func(tr *hbase1.TRowResult_) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i < len(chunks)-1 && bytes.Compare(tr.Row, chunks[i].Stop) >= 0 {
+			next()
+		}
+		v.hashRow(h, ToRow(tr))
+		targets[i].rows++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i < len(chunks) {
+		next()
+	}
+	return targets, nil
+}
+
+func (v *verifier) readChunk(client *HClient, tableName string, r KeyRange) ([]*Row, error) {
+	var rows []*Row
+	err := scanAll(client, tableName, v.scan(r), nil, func(tr *hbase1.TRowResult_) error {
+		rows = append(rows, ToRow(tr))
+		return nil
+	})
+	return rows, err
+}
+
+/*
+diffChunk compares the rows of a mismatched chunk by merging both sides
+*/
+func (v *verifier) diffChunk(ctx context.Context, from, to *HClient, r KeyRange) error {
+	source, err := v.readChunk(from, v.srcTable, r)
+	if err != nil {
+		return err
+	}
+	target, err := v.readChunk(to, v.dstTable, r)
+	if err != nil {
+		return err
+	}
+
+	for len(source) > 0 || len(target) > 0 {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		var d *RowDiff
+		switch {
+		case len(target) == 0 || (len(source) > 0 && bytes.Compare(source[0].Key, target[0].Key) < 0):
+			d = &RowDiff{Key: source[0].Key, Kind: OnlyInSource, Source: source[0]}
+			source = source[1:]
+		case len(source) == 0 || bytes.Compare(source[0].Key, target[0].Key) > 0:
+			d = &RowDiff{Key: target[0].Key, Kind: OnlyInTarget, Target: target[0]}
+			target = target[1:]
+		default:
+			if !v.sameCells(source[0], target[0]) {
+				d = &RowDiff{Key: source[0].Key, Kind: Different, Source: source[0], Target: target[0]}
+			}
+			source, target = source[1:], target[1:]
+		}
+		if d == nil {
+			continue
+		}
+
+		ok, more := v.found(d)
+		if !ok {
+			return nil
+		}
+		if v.conf.Repair {
+			if err = v.repair(to, d); err != nil {
+				return err
+			}
+			v.mu.Lock()
+			v.result.Repaired++
+			v.mu.Unlock()
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+/*
+found counts and reports the diff, ok is false when MaxDiffs was reached
+before it and more is false when it reaches MaxDiffs
+*/
+func (v *verifier) found(d *RowDiff) (ok, more bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.result.Truncated {
+		return false, false
+	}
+	v.result.Diffs++
+	if v.conf.Report != nil {
+		v.conf.Report(d)
+	}
+	if v.conf.MaxDiffs > 0 && v.result.Diffs >= int64(v.conf.MaxDiffs) {
+		v.result.Truncated = true
+		return true, false
+	}
+	return true, true
+}
+
+func (v *verifier) sameCells(a, b *Row) bool {
+	if len(a.Cells) != len(b.Cells) {
+		return false
+	}
+	for i, c := range a.Cells {
+		d := b.Cells[i]
+		if c.Family != d.Family || c.Qualifier != d.Qualifier || !bytes.Equal(c.Value, d.Value) {
+			return false
+		}
+		if v.conf.Timestamps && c.Timestamp != d.Timestamp {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+repair makes the target row match the source row
+*/
+func (v *verifier) repair(client *HClient, d *RowDiff) error {
+	if d.Source == nil {
+		return client.DeleteAllRow(v.dstTable, d.Key, nil)
+	}
+
+	m := NewRowMutation(d.Key)
+	var target *Row
+	if d.Target != nil {
+		target = d.Target
+		for _, c := range target.Cells {
+			if d.Source.Cell(c.Family, c.Qualifier) == nil {
+				m.Timestamp(0).DeleteColumn(c.Column())
+			}
+		}
+	}
+	for _, c := range d.Source.Cells {
+		var t *Cell
+		if target != nil {
+			t = target.Cell(c.Family, c.Qualifier)
+		}
+		switch {
+		case t == nil || t.Timestamp < c.Timestamp:
+			m.Timestamp(c.Timestamp)
+		case bytes.Equal(t.Value, c.Value) && (!v.conf.Timestamps || t.Timestamp == c.Timestamp):
+			continue
+		default:
+			m.Timestamp(0)
+		}
+		m.Put(c.Column(), c.Value)
+	}
+	return client.sendRowMutation(v.dstTable, m, nil)
+}